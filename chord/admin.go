@@ -0,0 +1,242 @@
+package chord
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+type (
+	// PeerState is a reference to a node in NodeState.
+	PeerState struct {
+		Addr   string `json:"addr"`
+		ID     uint64 `json:"id"`
+		Failed bool   `json:"failed,omitempty"`
+	}
+	// FingerState is a slot of finger table.
+	FingerState struct {
+		Index      int         `json:"index"`
+		Node       *PeerState  `json:"node"`
+		Candidates []PeerState `json:"candidates,omitempty"`
+	}
+	// NodeState is a snapshot of node's routing state.
+	NodeState struct {
+		PeerState
+		Predecessor *PeerState    `json:"predecessor"`
+		Successors  []PeerState   `json:"successors"`
+		Fingers     []FingerState `json:"fingers"`
+		Keys        int           `json:"keys"`
+	}
+	// Admin serves state of Node and operations on it over HTTP.
+	Admin struct {
+		Node *Node
+		// Locker serializes admin operations with other maintenance of Node.
+		// nil means admin's own lock.
+		Locker sync.Locker
+
+		m sync.Mutex
+	}
+)
+
+func peerState(n *Node) *PeerState {
+	if n == nil {
+		return nil
+	}
+	return &PeerState{Addr: n.addr, ID: n.id, Failed: n.fail()}
+}
+
+// State returns a snapshot of n's routing state.
+func (n *Node) State() NodeState {
+	s := NodeState{PeerState: *peerState(n), Predecessor: peerState(n.pred())}
+	for _, p := range n.succs() {
+		if p != nil {
+			s.Successors = append(s.Successors, *peerState(p))
+		}
+	}
+	fingers, candidates := n.routes()
+	for i, f := range fingers {
+		fs := FingerState{Index: i, Node: peerState(f)}
+		if i < len(candidates) {
+			for _, c := range candidates[i] {
+				fs.Candidates = append(fs.Candidates, *peerState(c))
+			}
+		}
+		s.Fingers = append(s.Fingers, fs)
+	}
+	n.m.Lock()
+	s.Keys = len(n.data)
+	n.m.Unlock()
+	return s
+}
+
+// maxRingView bounds nodes drawn in ring views.
+const maxRingView = 1024
+
+// ringNodes walks successors from n and returns visited nodes sorted by id.
+func ringNodes(n *Node) []*Node {
+	seen := map[*Node]bool{}
+	var nodes []*Node
+	for c := n; c != nil && !seen[c] && len(nodes) < maxRingView; c = c.Successor() {
+		seen[c] = true
+		nodes = append(nodes, c)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+// WriteDOT writes successors, fingers and predecessors of nodes in DOT language.
+func WriteDOT(w io.Writer, nodes []*Node) error {
+	if _, err := fmt.Fprintln(w, "digraph network {"); err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if n.fail() {
+			fmt.Fprintf(w, "    \"id:%d\" [style = filled, fillcolor = gray80];\n", n.id)
+		}
+		for _, s := range n.succs() {
+			if s != nil {
+				fmt.Fprintf(w, "    \"id:%d\" -> \"id:%d\" [weight = 100, label = succ, color = deeppink];\n", n.id, s.id)
+			}
+		}
+		fingers, _ := n.routes()
+		for _, f := range fingers {
+			if f != nil {
+				fmt.Fprintf(w, "    \"id:%d\" -> \"id:%d\" [style = \"dotted\", arrowsize = 0.5, color = gray80];\n", n.id, f.id)
+			}
+		}
+		if p := n.pred(); p != nil {
+			fmt.Fprintf(w, "    \"id:%d\" -> \"id:%d\" [weight = 100, label = predecessor, color = deepskyblue1];\n", n.id, p.id)
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// WriteSVG draws nodes on a circle in id order with successor and finger edges.
+func WriteSVG(w io.Writer, nodes []*Node) error {
+	const size, r = 600.0, 250.0
+	pos := map[*Node][2]float64{}
+	for i, n := range nodes {
+		a := 2*math.Pi*float64(i)/float64(len(nodes)) - math.Pi/2
+		pos[n] = [2]float64{size/2 + r*math.Cos(a), size/2 + r*math.Sin(a)}
+	}
+	if _, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g">`+"\n",
+		size, size, size, size); err != nil {
+		return err
+	}
+	line := func(from, to *Node, style string) {
+		p, ok1 := pos[from]
+		q, ok2 := pos[to]
+		if ok1 && ok2 && from != to {
+			fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" %s/>`+"\n", p[0], p[1], q[0], q[1], style)
+		}
+	}
+	for _, n := range nodes {
+		fingers, _ := n.routes()
+		for _, f := range fingers {
+			line(n, f, `stroke="gray" stroke-dasharray="2,3"`)
+		}
+		line(n, n.Successor(), `stroke="deeppink" stroke-width="2"`)
+	}
+	for _, n := range nodes {
+		fill := "deepskyblue"
+		if n.fail() {
+			fill = "gray"
+		}
+		p := pos[n]
+		fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="6" fill="%s"><title>id:%d</title></circle>`+"\n", p[0], p[1], fill, n.id)
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" font-size="10">%s</text>`+"\n", p[0]+8, p[1]-8, html.EscapeString(n.addr))
+	}
+	_, err := fmt.Fprintln(w, "</svg>")
+	return err
+}
+
+// AddAdmin adds admin endpoints of a.Node under path to mux.
+//
+//	GET  path              node state as JSON
+//	GET  path/ring.dot     ring seen from the node in DOT
+//	GET  path/ring.svg     ring seen from the node in SVG
+//	POST path/stabilize    run stabilize once
+//	POST path/fixfingers   fix every finger once
+//	POST path/drain        leave the ring handing keys to successor
+//
+// auth wraps every handler when it is not nil,
+// e.g. the authenticator returned by login.AddService.
+func AddAdmin(m *http.ServeMux, path string, a *Admin, auth func(http.HandlerFunc) http.HandlerFunc) {
+	wrap := func(h http.HandlerFunc) http.HandlerFunc {
+		if auth == nil {
+			return h
+		}
+		return auth(h)
+	}
+	m.HandleFunc(path, wrap(a.get(a.stateHandler)))
+	m.HandleFunc(path+"/ring.dot", wrap(a.get(a.dotHandler)))
+	m.HandleFunc(path+"/ring.svg", wrap(a.get(a.svgHandler)))
+	m.HandleFunc(path+"/stabilize", wrap(a.post(a.Node.stabilize)))
+	m.HandleFunc(path+"/fixfingers", wrap(a.post(func() {
+		for i := uint32(0); i <= a.Node.lastIndex; i++ {
+			a.Node.fixFigures()
+		}
+	})))
+	m.HandleFunc(path+"/drain", wrap(a.post(func() {
+		a.Node.Leave()
+	})))
+}
+
+func (a *Admin) lock() func() {
+	l := a.Locker
+	if l == nil {
+		l = &a.m
+	}
+	l.Lock()
+	return l.Unlock
+}
+
+func (a *Admin) get(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		defer a.lock()()
+		h(w, r)
+	}
+}
+
+// post runs op and responds the state after it.
+func (a *Admin) post(op func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		defer a.lock()()
+		if a.Node.fail() {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(ErrNodeFailed.Error()))
+			return
+		}
+		op()
+		a.stateHandler(w, r)
+	}
+}
+
+func (a *Admin) stateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Node.State())
+}
+
+func (a *Admin) dotHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/vnd.graphviz")
+	WriteDOT(w, ringNodes(a.Node))
+}
+
+func (a *Admin) svgHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	WriteSVG(w, ringNodes(a.Node))
+}
@@ -0,0 +1,86 @@
+package chord
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	ring := generateNodes(4, 0, 4)
+	setupRingStatically(ring, 1)
+	ring[1].storeLocal("4", []byte("v"))
+	m := http.NewServeMux()
+	AddAdmin(m, "/admin", &Admin{Node: ring[1]}, nil)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	state := func(w *httptest.ResponseRecorder) NodeState {
+		s := NodeState{}
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatalf("invalid state %q: %s", w.Body.String(), err)
+		}
+		return s
+	}
+
+	s := state(do("GET", "/admin"))
+	if s.ID != 4 || s.Predecessor.ID != 0 || len(s.Successors) != 1 || s.Successors[0].ID != 8 || s.Keys != 1 {
+		t.Errorf("unexpected state %+v", s)
+	}
+	if w := do("GET", "/admin/ring.dot"); !strings.Contains(w.Body.String(), `"id:4" -> "id:8"`) {
+		t.Errorf("ring.dot lacks successor edge:\n%s", w.Body)
+	}
+	if w := do("GET", "/admin/ring.svg"); !strings.HasPrefix(w.Body.String(), "<svg") ||
+		strings.Count(w.Body.String(), "<circle") != 4 {
+		t.Errorf("ring.svg doesn't draw the ring:\n%s", w.Body)
+	}
+	if w := do("GET", "/admin/stabilize"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET stabilize returned %d", w.Code)
+	}
+
+	// a new node joins between ring[1] and ring[2]
+	late := NewNode("6", 4, generateTestHash(16))
+	late.joinRing(ring[0])
+	if s := state(do("POST", "/admin/stabilize")); s.Successors[0].ID != 6 {
+		t.Errorf("stabilize didn't adopt new successor: %+v", s)
+	}
+	if s := state(do("POST", "/admin/fixfingers")); len(s.Fingers) < 2 {
+		t.Errorf("fixfingers didn't fill fingers: %+v", s)
+	}
+	if s := state(do("POST", "/admin/drain")); !s.Failed || s.Keys != 0 {
+		t.Errorf("drained node still serves: %+v", s)
+	}
+	if _, ok := late.loadLocal("4"); !ok {
+		t.Errorf("keys were not handed to successor")
+	}
+	if w := do("POST", "/admin/stabilize"); w.Code != http.StatusConflict {
+		t.Errorf("drained node accepted stabilize: %d", w.Code)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	ring := generateNodes(1, 0, 1)
+	ring[0].createNewRing()
+	m := http.NewServeMux()
+	deny := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+		}
+	}
+	AddAdmin(m, "/admin", &Admin{Node: ring[0]}, deny)
+	for _, path := range []string{"/admin", "/admin/ring.svg", "/admin/drain"} {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Errorf("%s was served without auth: %d", path, w.Code)
+		}
+	}
+	if ring[0].fail() {
+		t.Errorf("node was drained without auth")
+	}
+}
@@ -0,0 +1,160 @@
+package chord
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// Anti-entropy
+//
+// Replicas of an arc diverge when writes reach only some of them or a
+// replica restarts empty. Each node keeps a Merkle tree per arc of the
+// ring it stores. A round compares the tree of n's own arc,
+// (predecessor, n], with the one of a replica in its successor list and
+// exchanges keys of differing leaves only. The newer value wins.
+
+// merkleDepth is the depth of trees; an arc is split into 1<<merkleDepth leaves.
+const merkleDepth = 6
+
+// merkleTree is a complete binary tree over arc (from, to] in heap order:
+// hashes[1] is the root and leaves start at 1<<merkleDepth.
+type merkleTree struct {
+	version uint64
+	hashes  [2 << merkleDepth][sha1.Size]byte
+}
+
+// leafOf returns the leaf of arc (from, to] covering id.
+// from == to means the whole ring.
+func leafOf(from, to, id uint64) int {
+	off := id - from - 1
+	width := to - from
+	if width == 0 {
+		return int(off >> (64 - merkleDepth))
+	}
+	hi, lo := bits.Mul64(off, 1<<merkleDepth)
+	q, _ := bits.Div64(hi, lo, width)
+	return int(q)
+}
+
+// inArc reports whether id is in (from, to].
+func inArc(from, to, id uint64) bool {
+	return id == to || between(from, id, to)
+}
+
+// merkle returns n's tree over (from, to], rebuilding it after writes.
+func (n *Node) merkle(from, to uint64) *merkleTree {
+	n.m.Lock()
+	defer n.m.Unlock()
+	k := [2]uint64{from, to}
+	if t, ok := n.trees[k]; ok && t.version == n.version {
+		return t
+	}
+	leaves := make([][]string, 1<<merkleDepth)
+	for key := range n.data {
+		if id := n.Hash(key); inArc(from, to, id) {
+			l := leafOf(from, to, id)
+			leaves[l] = append(leaves[l], key)
+		}
+	}
+	t := &merkleTree{version: n.version}
+	for i, keys := range leaves {
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Strings(keys)
+		h := sha1.New()
+		for _, key := range keys {
+			e := n.data[key]
+			h.Write([]byte(key))
+			h.Write([]byte{0})
+			h.Write(e.value)
+			binary.Write(h, binary.BigEndian, e.stamp)
+			binary.Write(h, binary.BigEndian, e.expire)
+			binary.Write(h, binary.BigEndian, e.tomb)
+			binary.Write(h, binary.BigEndian, e.deleted)
+		}
+		copy(t.hashes[1<<merkleDepth+i][:], h.Sum(nil))
+	}
+	for i := 1<<merkleDepth - 1; i > 0; i-- {
+		l, r := t.hashes[2*i], t.hashes[2*i+1]
+		if l == r && l == ([sha1.Size]byte{}) {
+			continue
+		}
+		t.hashes[i] = sha1.Sum(append(l[:], r[:]...))
+	}
+	if n.trees == nil {
+		n.trees = map[[2]uint64]*merkleTree{}
+	}
+	// keep trees of the current arcs only
+	if len(n.trees) > 2*n.replicas {
+		n.trees = map[[2]uint64]*merkleTree{}
+	}
+	n.trees[k] = t
+	return t
+}
+
+// leafData returns a copy of keys in leaf of (from, to].
+func (n *Node) leafData(from, to uint64, leaf int) map[string]entry {
+	n.m.Lock()
+	defer n.m.Unlock()
+	data := map[string]entry{}
+	for k, v := range n.data {
+		if id := n.Hash(k); inArc(from, to, id) && leafOf(from, to, id) == leaf {
+			data[k] = v
+		}
+	}
+	return data
+}
+
+// syncArc makes keys of (from, to] on n and r equal.
+// It returns the number of differing leaves and repaired keys.
+func (n *Node) syncArc(r *Node, from, to uint64) (divergent, repaired int) {
+	a, b := n.merkle(from, to), r.merkle(from, to)
+	var walk func(i int)
+	walk = func(i int) {
+		if a.hashes[i] == b.hashes[i] {
+			return
+		}
+		if i < 1<<merkleDepth {
+			walk(2 * i)
+			walk(2*i + 1)
+			return
+		}
+		divergent++
+		leaf := i - 1<<merkleDepth
+		mine, theirs := n.leafData(from, to, leaf), r.leafData(from, to, leaf)
+		for k, v := range mine {
+			if w, ok := theirs[k]; (!ok || v.newer(w)) && r.storeEntry(k, v) {
+				repaired++
+			}
+		}
+		for k, v := range theirs {
+			if w, ok := mine[k]; (!ok || v.newer(w)) && n.storeEntry(k, v) {
+				repaired++
+			}
+		}
+	}
+	walk(1)
+	return divergent, repaired
+}
+
+// antiEntropy compares n's arc with the next replica.
+// It sweeps keys n no longer stores once per cycle over replicas.
+func (n *Node) antiEntropy() {
+	p := n.pred()
+	if n.replicas <= 1 || p == nil || p == n || n.unreachable(p) {
+		return
+	}
+	rs := n.replicaSet()
+	if len(rs) == 0 {
+		return
+	}
+	n.nextReplica = (n.nextReplica + 1) % len(rs)
+	if n.nextReplica == 0 {
+		n.setMisplaced()
+	}
+	d, r := n.syncArc(rs[n.nextReplica], p.id, n.id)
+	n.metric().AntiEntropy(d, r)
+}
@@ -0,0 +1,117 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestLeafOf(t *testing.T) {
+	for _, c := range []struct {
+		from, to, id uint64
+		leaf         int
+	}{
+		{0, 64, 1, 0},
+		{0, 64, 64, 63},
+		{0, 64, 33, 32},
+		{60, 4, 61, 0},
+		{60, 4, 4, 63},
+		{5, 5, 6, 0},
+		{5, 5, 5, 63},
+	} {
+		if l := leafOf(c.from, c.to, c.id); l != c.leaf {
+			t.Errorf("leafOf(%d, %d, %d) = %d; expected %d", c.from, c.to, c.id, l, c.leaf)
+		}
+	}
+}
+
+func TestAntiEntropy(t *testing.T) {
+	ring := generateNodes(4, 0, 16)
+	setupRingStatically(ring, 3)
+	counters := NewCounters(ring[1])
+	ring[1].SetMetrics(counters)
+	for _, n := range ring {
+		n.SetReplicationFactor(3)
+	}
+	// keys of (0, 16] are stored on ring[1] and replicated to ring[2], ring[3]
+	for i := 1; i <= 16; i++ {
+		key := fmt.Sprintf("%x", i)
+		if err := ring[0].Put(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range ring[2:] {
+		if b, ok := r.loadLocal("5"); !ok || string(b) != "5" {
+			t.Fatalf("id:%d doesn't replicate key 5", r.id)
+		}
+	}
+	// replicas diverge silently
+	ring[2].m.Lock()
+	delete(ring[2].data, "3")
+	ring[2].data["7"] = entry{value: []byte("stale")}
+	ring[2].version++
+	ring[2].m.Unlock()
+	ring[1].m.Lock()
+	delete(ring[1].data, "b")
+	ring[1].version++
+	ring[1].m.Unlock()
+	ring[3].storeLocal("20", []byte("not in the arc"))
+
+	ring[1].antiEntropy()
+	ring[1].antiEntropy()
+	for _, r := range ring[2:] {
+		if d, _ := ring[1].syncArc(r, ring[0].id, ring[1].id); d != 0 {
+			t.Errorf("id:%d still differs in %d leaves", r.id, d)
+		}
+	}
+	if b, _ := ring[2].loadLocal("7"); string(b) != "7" {
+		t.Errorf("stale value wasn't repaired: %q", b)
+	}
+	if b, _ := ring[1].loadLocal("b"); string(b) != "b" {
+		t.Errorf("key missing on owner wasn't repaired: %q", b)
+	}
+	if _, ok := ring[1].loadLocal("20"); ok {
+		t.Errorf("key out of the arc was copied")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	WritePrometheus(buf, counters)
+	for _, expected := range []string{
+		`chord_antientropy_rounds_total{node="10"} 2`,
+		`chord_antientropy_divergent_leaves_total{node="10"} 3`,
+		`chord_antientropy_repaired_keys_total{node="10"} 3`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("%q is not exported:\n%s", expected, buf)
+		}
+	}
+}
+
+func TestReplicasSurviveOwnerFailure(t *testing.T) {
+	ring := generateNodes(4, 0, 16)
+	setupRingStatically(ring, 3)
+	for _, n := range ring {
+		n.SetSuccessorListLength(3)
+		n.SetReplicationFactor(3)
+	}
+	ring[0].Put("5", strings.NewReader("v"))
+	ring[1].Fail()
+	for round := 0; round < 4; round++ {
+		for _, n := range aliveNodes(ring) {
+			n.Maintain()
+		}
+	}
+	r, err := ring[0].Get("5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(r); string(b) != "v" {
+		t.Errorf("unexpected value %q", b)
+	}
+	// the new owner restores the replication factor on its second successor
+	if _, ok := ring[0].loadLocal("5"); !ok {
+		t.Errorf("key was not replicated to id:0")
+	}
+}
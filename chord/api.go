@@ -0,0 +1,114 @@
+package chord
+
+// ID returns n's id in logical key space.
+func (n *Node) ID() uint64 {
+	return n.id
+}
+
+// Addr returns n's address.
+func (n *Node) Addr() string {
+	return n.addr
+}
+
+// Successor returns the first entry of n's successor list.
+func (n *Node) Successor() *Node {
+	succs := n.succs()
+	if len(succs) == 0 {
+		return nil
+	}
+	return succs[0]
+}
+
+// Predecessor returns n's predecessor or nil if it is unknown.
+func (n *Node) Predecessor() *Node {
+	return n.pred()
+}
+
+// SetSuccessorListLength sets r, the number of successors n keeps.
+// The ring survives failures of less than r adjacent nodes.
+func (n *Node) SetSuccessorListLength(r int) {
+	n.succListLen = r
+}
+
+// SetReplicationFactor sets k, the number of nodes storing a key:
+// its owner and the first k-1 successors. k is at most r.
+func (n *Node) SetReplicationFactor(k int) {
+	n.replicas = k
+}
+
+// Create starts a new ring which has only n.
+func (n *Node) Create() {
+	n.createNewRing()
+}
+
+// Join makes n a member of the ring j belongs to.
+func (n *Node) Join(j *Node) error {
+	if j == nil {
+		return ErrEmptyNode
+	}
+	return n.joinRing(j)
+}
+
+// Maintain runs one round of the periodic maintenance protocols.
+func (n *Node) Maintain() {
+	if n.fail() {
+		return
+	}
+	n.checkPredecessor()
+	n.stabilize()
+	n.fixFigures()
+	n.probe()
+	n.handoff()
+	n.antiEntropy()
+	n.rehome()
+	n.collectExpired()
+	n.repairTopics()
+}
+
+// Lookup returns the node responsible for k and the number of hops it took.
+func (n *Node) Lookup(k uint64) (*Node, int, error) {
+	if n == nil {
+		return nil, 0, ErrEmptyNode
+	}
+	if n.fail() {
+		return nil, 0, ErrNodeFailed
+	}
+	s, hops := n.lookup(k, 0)
+	var err error
+	if s == nil {
+		err = ErrEmptyNode
+	} else if s.fail() {
+		err = ErrNodeFailed
+	}
+	n.metric().Lookup(hops, err)
+	return s, hops, err
+}
+
+// Leave hands n's keys to its successor and leaves the ring gracefully.
+func (n *Node) Leave() error {
+	if n.fail() {
+		return ErrNodeFailed
+	}
+	s, p := n.liveSuccessor(), n.pred()
+	if s != nil && s != n && !n.unreachable(s) {
+		n.keysTransferred(s, n.handOver(s))
+		if s.pred() == n {
+			s.setPredecessor(p)
+		}
+		if p != nil && p != n && p.Successor() == n {
+			p.setSuccessors(p.successorList(s))
+		}
+	}
+	n.rm.Lock()
+	n.failed = true
+	n.rm.Unlock()
+	return nil
+}
+
+// Fail makes n crash. Peers see it failed from now on.
+// It is for simulations; real nodes fail by themselves.
+func (n *Node) Fail() {
+	n.rm.Lock()
+	defer n.rm.Unlock()
+	n.failed = true
+}
@@ -0,0 +1,257 @@
+package chord
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSeed      = errors.New("no seed is reachable")
+	ErrUnknownPeer = errors.New("peer is unknown")
+)
+
+// Discovery finds addresses of nodes which may be members of a ring.
+type Discovery interface {
+	Discover() ([]string, error)
+}
+
+// Bootstrap decides how a starting node finds its ring.
+type Bootstrap struct {
+	// Discovery is tried in order until a seed accepts the node.
+	Discovery []Discovery
+	// Resolve returns the node at addr.
+	Resolve func(addr string) (*Node, error)
+	// AllowCreate lets the node start a new ring when no seed is reachable.
+	// Leave it false on all but one node, or clusters started at the same
+	// time form separate rings.
+	AllowCreate bool
+}
+
+// Start joins n to a ring through seeds found by b.Discovery.
+// It creates a new ring only if b.AllowCreate is set and no seed is reachable.
+// Without a ring it returns ErrNoSeed joined with why each seed failed.
+// ErrIDConflict stops it at once; n must not join any ring by its address.
+// n keeps b.Resolve and the seeds to probe them for other rings later.
+func (n *Node) Start(b Bootstrap) error {
+	n.resolve = b.Resolve
+	errs := []error{ErrNoSeed}
+	for _, d := range b.Discovery {
+		addrs, err := d.Discover()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n.Remember(addrs...)
+		for _, addr := range addrs {
+			if addr == n.addr || b.Resolve == nil {
+				continue
+			}
+			j, err := b.Resolve(addr)
+			if err == nil && j == nil {
+				err = ErrEmptyNode
+			} else if err == nil && n.unreachable(j) {
+				err = ErrNodeFailed
+			} else if err == nil {
+				if err = n.Join(j); err == nil {
+					return nil
+				} else if err == ErrIDConflict {
+					return err
+				}
+			}
+			errs = append(errs, fmt.Errorf("seed %s: %w", addr, err))
+		}
+	}
+	if !b.AllowCreate {
+		return errors.Join(errs...)
+	}
+	n.Create()
+	return nil
+}
+
+// Registry resolves addresses to nodes running in this process.
+type Registry struct {
+	m     sync.RWMutex
+	nodes map[string]*Node
+}
+
+// Register makes n resolvable by its address.
+func (r *Registry) Register(n *Node) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.nodes == nil {
+		r.nodes = map[string]*Node{}
+	}
+	r.nodes[n.addr] = n
+}
+
+// Unregister removes the node at addr.
+func (r *Registry) Unregister(addr string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	delete(r.nodes, addr)
+}
+
+// Resolve returns the node registered at addr.
+func (r *Registry) Resolve(addr string) (*Node, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	n, ok := r.nodes[addr]
+	if !ok {
+		return nil, ErrUnknownPeer
+	}
+	return n, nil
+}
+
+// StaticSeeds is a fixed list of seed addresses.
+type StaticSeeds []string
+
+// Discover returns the seeds.
+func (s StaticSeeds) Discover() ([]string, error) {
+	return s, nil
+}
+
+// PeerFile reads seed addresses from a file, one per line.
+// Blank lines and lines starting with '#' are ignored.
+type PeerFile string
+
+// Discover reads the file.
+func (f PeerFile) Discover() ([]string, error) {
+	fp, err := os.Open(string(f))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	var addrs []string
+	s := bufio.NewScanner(fp)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		addrs = append(addrs, l)
+	}
+	return addrs, s.Err()
+}
+
+// DNSSRV looks seeds up with a SRV query, _Service._Proto.Name.
+type DNSSRV struct {
+	Service, Proto, Name string
+	// Server is the address of the resolver, e.g. "127.0.0.1:53".
+	// Empty means the resolver of the system.
+	Server  string
+	Timeout time.Duration
+}
+
+// Discover returns "target:port" of the records in the order of priority.
+func (d DNSSRV) Discover() ([]string, error) {
+	r := net.DefaultResolver
+	if d.Server != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, d.Server)
+			},
+		}
+	}
+	ctx := context.Background()
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	_, srvs, err := r.LookupSRV(ctx, d.Service, d.Proto, d.Name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(srvs))
+	for _, s := range srvs {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(s.Target, "."), strconv.Itoa(int(s.Port))))
+	}
+	return addrs, nil
+}
+
+// multicastQuery is the payload of discovery requests.
+const multicastQuery = "chord discover"
+
+// Multicast asks nodes on the LAN for their addresses over UDP multicast.
+// Nodes answer it with Announce.
+type Multicast struct {
+	// Group is the multicast address, e.g. "239.255.77.77:7777".
+	Group string
+	// Wait is how long answers are collected. Zero means 1 second.
+	Wait time.Duration
+}
+
+// Discover sends a query to the group and returns the addresses answered.
+func (m Multicast) Discover() ([]string, error) {
+	g, err := net.ResolveUDPAddr("udp", m.Group)
+	if err != nil {
+		return nil, err
+	}
+	c, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if _, err := c.WriteToUDP([]byte(multicastQuery), g); err != nil {
+		return nil, err
+	}
+	wait := m.Wait
+	if wait <= 0 {
+		wait = time.Second
+	}
+	c.SetReadDeadline(time.Now().Add(wait))
+	seen := map[string]bool{}
+	var addrs []string
+	buf := make([]byte, 512)
+	for {
+		l, _, err := c.ReadFromUDP(buf)
+		if err != nil {
+			// deadline ends the collection
+			break
+		}
+		if a := string(buf[:l]); !seen[a] {
+			seen[a] = true
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs, nil
+}
+
+// Announce answers multicast queries on group with addr until ctx is done.
+func Announce(ctx context.Context, group, addr string) error {
+	g, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return err
+	}
+	c, err := net.ListenMulticastUDP("udp", nil, g)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+	buf := make([]byte, 512)
+	for {
+		l, from, err := c.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if string(buf[:l]) == multicastQuery {
+			c.WriteToUDP([]byte(addr), from)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package chord
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStart(t *testing.T) {
+	ring := generateNodes(4, 0, 4)
+	setupRingStatically(ring, 2)
+	r := &Registry{}
+	for _, n := range ring {
+		r.Register(n)
+	}
+	ring[0].Fail()
+
+	dir, err := ioutil.TempDir("", "chord")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	peers := filepath.Join(dir, "peers")
+	ioutil.WriteFile(peers, []byte("# seeds\n\nf\n8\n"), 0600)
+
+	n := NewNode("6", 4, generateTestHash(16))
+	err = n.Start(Bootstrap{
+		// missing file, unknown, failed, then a live seed
+		Discovery: []Discovery{PeerFile(filepath.Join(dir, "none")), StaticSeeds{"f", "0"}, PeerFile(peers)},
+		Resolve:   r.Resolve,
+	})
+	if err != nil {
+		t.Fatalf("start failed: %s", err)
+	}
+	if s := n.Successor(); s != ring[2] {
+		t.Errorf("joined at wrong place: successor %s", describeNodes([]*Node{s}))
+	}
+}
+
+func TestStartWithoutSeed(t *testing.T) {
+	n := NewNode("6", 4, generateTestHash(16))
+	b := Bootstrap{Discovery: []Discovery{StaticSeeds{"6", "8"}}, Resolve: (&Registry{}).Resolve}
+	if err := n.Start(b); !errors.Is(err, ErrNoSeed) || !errors.Is(err, ErrUnknownPeer) {
+		t.Errorf("expected ErrNoSeed of unknown peers, but %v", err)
+	}
+	if n.Successor() != nil {
+		t.Errorf("node created a ring without permission")
+	}
+	b.AllowCreate = true
+	if err := n.Start(b); err != nil || n.Successor() != n {
+		t.Errorf("node didn't create a ring: %v", err)
+	}
+}
+
+func TestStartWithConflictingID(t *testing.T) {
+	ring := generateNodes(4, 0, 4)
+	setupRingStatically(ring, 2)
+	r := &Registry{}
+	for _, n := range ring {
+		r.Register(n)
+	}
+	// "4" has the id of ring[1]; the other seed is not tried
+	n := NewNode("4", 4, generateTestHash(16))
+	b := Bootstrap{Discovery: []Discovery{StaticSeeds{"0", "8"}}, Resolve: r.Resolve, AllowCreate: true}
+	if err := n.Start(b); err != ErrIDConflict {
+		t.Errorf("expected ErrIDConflict, but %v", err)
+	}
+	if n.Successor() != nil {
+		t.Errorf("node of a conflicting id created a ring")
+	}
+}
+
+func TestDNSSRV(t *testing.T) {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+	go serveSRV(c, []srvRecord{{1, 10, 7001, "b.ring.test."}, {0, 10, 7000, "a.ring.test."}})
+
+	d := DNSSRV{Service: "chord", Proto: "tcp", Name: "ring.test.", Server: c.LocalAddr().String(), Timeout: 3 * time.Second}
+	addrs, err := d.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a.ring.test:7000", "b.ring.test:7001"}; !reflect.DeepEqual(addrs, expected) {
+		t.Errorf("expected %v, but %v", expected, addrs)
+	}
+}
+
+type srvRecord struct {
+	priority, weight, port uint16
+	target                 string
+}
+
+// serveSRV answers every query with records.
+func serveSRV(c net.PacketConn, records []srvRecord) {
+	buf := make([]byte, 512)
+	for {
+		l, from, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		q := buf[:l]
+		// question ends with qtype and qclass after the name
+		end := 12
+		for end < l && q[end] != 0 {
+			end += int(q[end]) + 1
+		}
+		end += 5
+		if end > l {
+			continue
+		}
+		res := append([]byte{}, q[:2]...)
+		res = append(res, 0x81, 0x80, 0, 1, 0, byte(len(records)), 0, 0, 0, 0)
+		res = append(res, q[12:end]...)
+		for _, r := range records {
+			var name []byte
+			for _, label := range splitLabels(r.target) {
+				name = append(append(name, byte(len(label))), label...)
+			}
+			name = append(name, 0)
+			rr := []byte{0xc0, 12, 0, 33, 0, 1, 0, 0, 0, 60}
+			rr = binary.BigEndian.AppendUint16(rr, uint16(6+len(name)))
+			rr = binary.BigEndian.AppendUint16(rr, r.priority)
+			rr = binary.BigEndian.AppendUint16(rr, r.weight)
+			rr = binary.BigEndian.AppendUint16(rr, r.port)
+			res = append(append(res, rr...), name...)
+		}
+		c.WriteTo(res, from)
+	}
+}
+
+func splitLabels(name string) []string {
+	var labels []string
+	s := 0
+	for i := 0; i < len(name); i++ {
+		if name[i] == '.' {
+			if i > s {
+				labels = append(labels, name[s:i])
+			}
+			s = i + 1
+		}
+	}
+	if s < len(name) {
+		labels = append(labels, name[s:])
+	}
+	return labels
+}
+
+func TestMulticast(t *testing.T) {
+	const group = "239.255.77.77:17777"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- Announce(ctx, group, "8") }()
+	select {
+	case err := <-errc:
+		t.Skipf("multicast is not available: %s", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	addrs, err := Multicast{Group: group, Wait: 300 * time.Millisecond}.Discover()
+	if err != nil || len(addrs) == 0 {
+		t.Skipf("multicast is not routed here: %v", err)
+	}
+	if addrs[0] != "8" {
+		t.Errorf("unexpected answer %v", addrs)
+	}
+}
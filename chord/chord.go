@@ -2,29 +2,36 @@ package chord
 
 import (
 	"errors"
-	"fmt"
 	"io"
+	"sync"
 
 	"github.com/masu-mi/gimmick.git/sets/s1"
 )
 
 // Node is Chord node.
-// accept
-// key -> id -> Node [ id -> Client ]
-//           -> Node [ id -> Client ]
-//           -> Node [ id -> Client ]
-//           -> Node [ id -> Server ]
-// reject
-// key -> Node [ key -> id -> ( key -> Client) ]
-//     -> Node [ key -> id -> ( key -> Client) ]
-//     -> Node [ key -> id -> ( key -> Client) ]
-//     -> Node [ key -> id -> Server ]
+//
+//	accept
+//	key -> id -> Node [ id -> Client ]
+//	          -> Node [ id -> Client ]
+//	          -> Node [ id -> Client ]
+//	          -> Node [ id -> Server ]
+//	reject
+//	key -> Node [ key -> id -> ( key -> Client) ]
+//	    -> Node [ key -> id -> ( key -> Client) ]
+//	    -> Node [ key -> id -> ( key -> Client) ]
+//	    -> Node [ key -> id -> Server ]
 type Node struct {
 	addr string
 	id   uint64
 
 	hash func(string) uint64
 
+	// rm guards the routing state: successors, predecessor, finger,
+	// candidates and failed, and peers, nextPeer and misplaced of merges.
+	// Maintenance writes it while lookups of clients and other nodes read
+	// it; readers take snapshots by succs, pred, routes and peerList.
+	// Entries of successors are never written in place.
+	rm          sync.RWMutex
 	successors  []*Node
 	predecessor *Node
 
@@ -32,6 +39,42 @@ type Node struct {
 	nextFinger uint32
 	lastIndex  uint32
 	failed     bool
+
+	// succListLen is r of Zave's protocol; the ring survives r-1 adjacent failures.
+	succListLen int
+
+	// candidates[i] holds nodes of finger[i]'s interval; finger[i] is the nearest of them.
+	candidates    [][]*Node
+	candidateSize int
+	transport     Transport
+
+	// m guards data and hints which clients touch concurrently with maintenance
+	m     sync.Mutex
+	data  map[string]entry
+	hints []hint
+
+	metrics     Metrics
+	em          sync.Mutex
+	subscribers map[chan Event]struct{}
+	keyWatchers map[*keyWatcher]struct{}
+
+	// peers are remembered addresses probed to find other rings.
+	resolve  func(addr string) (*Node, error)
+	peers    []string
+	nextPeer int
+	// misplaced is set while n may hold keys it is not responsible for.
+	misplaced bool
+
+	// replicas is the number of nodes storing a key: its owner and successors.
+	replicas    int
+	nextReplica int
+	// version counts writes to data; trees built at an older version are stale.
+	version uint64
+	trees   map[[2]uint64]*merkleTree
+
+	// tm guards topics; subscribers and publishers touch it concurrently with maintenance.
+	tm     sync.Mutex
+	topics map[string]*topic
 }
 
 // func (n *Node) Start(addrs ...string) error {
@@ -47,16 +90,19 @@ type Node struct {
 // }
 
 var (
-	ErrEmptyNode = errors.New("node nil")
+	ErrEmptyNode  = errors.New("node nil")
+	ErrNodeFailed = errors.New("node failed")
+	ErrIDConflict = errors.New("id is used by another node")
 )
 
 type StorageService interface {
 	Put(key string, value io.Reader) error
 	Get(key string) (value io.ReadCloser, err error)
 }
-type proxy struct {
-	Client *Client
-}
+
+// type proxy struct {
+// 	Client *Client
+// }
 
 // func (p *proxy) Put(key string, value io.Reader) error {
 // 	return p.Client.Put(key, value)
@@ -77,18 +123,31 @@ type proxy struct {
 
 // location, routing
 func (n *Node) locateSuccessor(k uint64) *Node {
-	if n == nil || len(n.successors) == 0 {
-		return nil
+	s, _ := n.lookup(k, 0)
+	return s
+}
+
+// maxHops bounds a lookup which loops on inconsistent fingers.
+const maxHops = 256
+
+// lookup returns successor of k and the number of hops the query took.
+func (n *Node) lookup(k uint64, hops int) (*Node, int) {
+	if n == nil || n.fail() || hops > maxHops {
+		return nil, hops
+	}
+	succ := n.liveSuccessor()
+	if succ == nil {
+		return nil, hops
 	}
 	if s1.Equal(n.id, k) {
-		return n
+		return n, hops
 	}
 	// RotationNumber() == 1 likes k <- [n, successor]; close and close
-	if s1.RotationNumber(n.id, k, n.successors[0].id) == 1 {
-		return n.successors[0]
+	if s1.RotationNumber(n.id, k, succ.id) == 1 {
+		return succ, hops + 1
 	}
 	next := n.closestPrecedingNode(k)
-	return next.locateSuccessor(k)
+	return next.lookup(k, hops+1)
 }
 func (n *Node) closestPrecedingNode(k uint64) *Node {
 	if n == nil {
@@ -97,20 +156,28 @@ func (n *Node) closestPrecedingNode(k uint64) *Node {
 	if s1.Equal(n.id, k) {
 		return n
 	}
-	if len(n.finger) == 0 && len(n.successors) > 0 {
-		return n.successors[0]
-	}
-	var result *Node
-	for i := len(n.finger); i > 0; i-- {
-		result = n.finger[i-1]
-		if result == nil {
+	fingers, candidates := n.routes()
+	for i := len(fingers); i > 0; i-- {
+		if fingers[i-1] == nil {
 			continue
 		}
-		if !s1.Equal(n.id, result.id) && s1.RotationNumber(n.id, result.id, k) == 1 {
-			break
+		var cands []*Node
+		if i-1 < len(candidates) {
+			cands = candidates[i-1]
+		}
+		if p := n.precedingCandidate(fingers[i-1], cands, k); p != nil {
+			return p
+		}
+	}
+	// no finger precedes k or all of them failed
+	succs := n.succs()
+	for i := len(succs); i > 0; i-- {
+		s := succs[i-1]
+		if s != nil && !n.unreachable(s) && !s1.Equal(n.id, s.id) && s1.RotationNumber(n.id, s.id, k) == 1 {
+			return s
 		}
 	}
-	return result
+	return n.liveSuccessor()
 }
 
 // Hash changes input key string to id in logical key space.
@@ -124,50 +191,126 @@ func hash(k string) uint64 {
 	return uint64(len(k))
 }
 
+// DefaultHash is the hash of nodes created without hash function.
+func DefaultHash(k string) uint64 {
+	return hash(k)
+}
+
 // NewNode creates empty Node.
 func NewNode(addr string, last uint32, hash func(string) uint64) *Node {
 	n := &Node{
 		addr: addr, hash: hash,
 		lastIndex: last,
 		finger:    make([]*Node, 0, last+1),
+
+		candidates:    make([][]*Node, 0, last+1),
+		candidateSize: defaultFingerCandidates,
+		succListLen:   defaultSuccessorListLen,
+		replicas:      1,
 	}
 	n.id = n.Hash(addr)
 	return n
 }
 
 // Rendezvous
+//
+// Maintenance follows Zave's corrected protocol
+// ("Reasoning about identifier spaces: How to make Chord correct", 2017).
+// Every node keeps r successors; stabilize skips dead ones and
+// notify rectifies predecessor, so the ring stays one ordered ring
+// under concurrent joins and failures of less than r adjacent nodes.
+
+// defaultSuccessorListLen is r of new nodes.
+const defaultSuccessorListLen = 3
+
 func (n *Node) createNewRing() {
-	n.predecessor = nil
-	n.successors = []*Node{n}
+	n.setPredecessor(nil)
+	n.setSuccessors([]*Node{n})
 }
-func (n *Node) joinRing(j *Node) {
-	n.predecessor = nil
+func (n *Node) joinRing(j *Node) error {
+	n.setPredecessor(nil)
 	suc := j.locateSuccessor(n.id)
-	n.successors = []*Node{suc}
+	if suc == nil {
+		return ErrEmptyNode
+	}
+	if suc != n && s1.Equal(suc.id, n.id) {
+		return ErrIDConflict
+	}
+	n.setSuccessors(n.successorList(suc))
 	// TODO stabilizeは自動実行だけど joinRing直後は即時にする?
 	// この手の下位操作と自動的にリングを維持するAPIと層を分けるといい事あるか??
 	n.stabilize()
+	return nil
 }
 
 // executed periodically to verify and inform successor
 func (n *Node) stabilize() {
-	prev := n.successors[0].predecessor
-	if prev == n {
-		return
+	if s := n.updateSuccessors(); s != nil {
+		s.notify(n)
 	}
-	if prev != nil && s1.RotationNumber(n.id, prev.id, n.successors[0].id) == 1 {
-		n.successors = []*Node{prev}
+}
+
+// updateSuccessors is the first half of stabilize.
+// It returns the successor to notify or nil when all successors failed.
+func (n *Node) updateSuccessors() *Node {
+	n.metric().Stabilized()
+	n.checkSuccessors()
+	succ := n.liveSuccessor()
+	if succ == nil {
+		// the ring is broken beyond r
+		return nil
+	}
+	list := n.successorList(succ)
+	if prev := succ.pred(); prev != nil && !n.unreachable(prev) && between(n.id, prev.id, succ.id) {
+		list = n.successorList(prev)
 	}
-	n.successors[0].notify(n)
+	n.setSuccessors(list)
+	return n.Successor()
 }
 
-// j believes it is predecessor of i
+// j believes it is predecessor of i (rectify in Zave's protocol)
 func (n *Node) notify(j *Node) {
-	if n.predecessor == nil || s1.RotationNumber(n.predecessor.id, j.id, n.id) == 1 {
+	p := n.pred()
+	if p == nil || n.unreachable(p) || between(p.id, j.id, n.id) {
 		// TODO mentenace service condition
-		// transfer keys in the range [j,i) to i; // 要検討
-		n.predecessor = j
+		n.setPredecessor(j)
+		// j is responsible for keys out of (j, n] now
+		n.keysTransferred(j, n.transferKeys(j))
+	}
+}
+
+// successorList returns s followed by s's successors, at most r entries.
+// It stops before wrapping around to n on rings smaller than r.
+func (n *Node) successorList(s *Node) []*Node {
+	r := n.succListLen
+	if r < 1 {
+		r = 1
+	}
+	list := make([]*Node, 0, r)
+	list = append(list, s)
+	for _, c := range s.succs() {
+		if len(list) >= r || c == nil || c == n || c == s {
+			break
+		}
+		list = append(list, c)
 	}
+	return list
+}
+
+// liveSuccessor returns the first reachable entry of successors.
+func (n *Node) liveSuccessor() *Node {
+	for _, s := range n.succs() {
+		if s != nil && !n.unreachable(s) {
+			return s
+		}
+	}
+	return nil
+}
+
+// between reports whether x is in open interval (a, b) on the ring.
+// (a, a) is the whole ring except a.
+func between(a, x, b uint64) bool {
+	return s1.RotationNumber(a, x, b) == 1 && !s1.Equal(x, a) && !s1.Equal(x, b)
 }
 
 // fixFingers executed periodically to pudate the finger table(n.finger)
@@ -176,28 +319,76 @@ func (n *Node) fixFigures() {
 	if n.nextFinger > n.lastIndex {
 		n.nextFinger = 0
 	}
-	terminal := n.locateSuccessor(n.Hash(fmt.Sprintf("%x", n.id+2<<(n.nextFinger))))
-	if terminal == n {
+	terminal := n.locateSuccessor(n.fingerStart(n.nextFinger))
+	if terminal == nil || terminal == n {
 		return
 	}
-	if len(n.finger) < int(n.lastIndex)+1 {
-		n.finger = append(n.finger, terminal)
-	} else {
-		n.finger[n.nextFinger] = terminal
-	}
+	// proximity neighbor selection: any node in the interval is a valid finger
+	cands := n.fingerCandidates(terminal, n.fingerEnd(n.nextFinger))
+	n.setFinger(int(n.nextFinger), cands)
 }
 
 // checkPredecessor executed periodically to verify whether predecessor still exists.
 func (n *Node) checkPredecessor() {
-	if n.predecessor.fail() {
-		n.predecessor = nil
+	if p := n.pred(); p != nil && n.unreachable(p) {
+		n.failureDetected(p)
+		n.setPredecessor(nil)
 	}
 }
+
+// checkSuccessors drops failed entries at the head of successors.
 func (n *Node) checkSuccessors() {
+	succs := n.succs()
+	i := 0
+	for ; i < len(succs)-1; i++ {
+		s := succs[i]
+		if s != nil && !n.unreachable(s) {
+			break
+		}
+		n.failureDetected(s)
+	}
+	if i > 0 {
+		n.setSuccessors(succs[i:])
+	}
 }
 
 // fail check network, Node, host, hardware failer exists.
 func (n *Node) fail() bool {
 	// for test always OK(false)
+	n.rm.RLock()
+	defer n.rm.RUnlock()
 	return n.failed
 }
+
+// succs returns successors. The slice is shared; callers must not modify it.
+func (n *Node) succs() []*Node {
+	n.rm.RLock()
+	defer n.rm.RUnlock()
+	return n.successors
+}
+
+// pred returns predecessor.
+func (n *Node) pred() *Node {
+	n.rm.RLock()
+	defer n.rm.RUnlock()
+	return n.predecessor
+}
+
+// routes returns copies of finger and candidates.
+func (n *Node) routes() ([]*Node, [][]*Node) {
+	n.rm.RLock()
+	defer n.rm.RUnlock()
+	return append([]*Node(nil), n.finger...), append([][]*Node(nil), n.candidates...)
+}
+
+// unreachable reports whether n can't talk to p:
+// p failed or the transport lost the route between them.
+func (n *Node) unreachable(p *Node) bool {
+	if p.fail() {
+		return true
+	}
+	if t, ok := n.transport.(Partitioner); ok {
+		return !t.Reachable(n.addr, p.addr)
+	}
+	return false
+}
@@ -168,7 +168,7 @@ func TestRendezvous(t *testing.T) {
 		oldPre := base.predecessor
 		n.joinRing(base)
 		if i == size-1 {
-			_ = createNetworkGraphFile(fmt.Sprintf("new_ring_join.dot", i), nodes)
+			_ = createNetworkGraphFile("new_ring_join.dot", nodes)
 		}
 		for i := 0; i < 4; i++ {
 			n.fixFigures()
@@ -190,7 +190,7 @@ func TestRendezvous(t *testing.T) {
 			oldPre.checkPredecessor()
 		}
 		if i == size-1 {
-			_ = createNetworkGraphFile(fmt.Sprintf("new_ring_old-pred-stabilized.dot", i), nodes)
+			_ = createNetworkGraphFile("new_ring_old-pred-stabilized.dot", nodes)
 		}
 	}
 	for _, n := range nodes {
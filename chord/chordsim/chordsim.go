@@ -0,0 +1,408 @@
+// Package chordsim simulates chord rings under churn.
+// Time advances in ticks; every alive node runs one round of maintenance per tick.
+package chordsim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/masu-mi/gimmick.git/chord"
+)
+
+// Config is a churn scenario.
+type Config struct {
+	// Nodes is the size of the initial ring.
+	Nodes int
+	// Ticks is the length of the churn phase.
+	Ticks int
+	// Arrival draws ticks between joins. nil means no joins.
+	Arrival Distribution
+	// Session draws ticks a node stays in the ring. nil means forever.
+	Session Distribution
+	// FailRatio is the ratio of departures which crash instead of leaving.
+	FailRatio float64
+	// Replicas is the replication factor of keys. Zero means 1.
+	Replicas int
+	// Keys is the number of keys stored after the initial ring settled.
+	Keys int
+	// LookupsPerTick is the number of random lookups measured every tick.
+	LookupsPerTick int
+	// SampleEvery is the number of ticks aggregated into a Sample.
+	SampleEvery int
+	// Settle bounds ticks spent to converge before and after churn.
+	Settle int
+	Seed   int64
+	// PartitionRatio is the ratio of nodes cut off from the others
+	// from tick PartitionAt for PartitionFor ticks. Zero means no partition.
+	PartitionRatio float64
+	PartitionAt    int
+	PartitionFor   int
+}
+
+// Sample aggregates SampleEvery ticks.
+type Sample struct {
+	Tick      int     `json:"tick"`
+	Alive     int     `json:"alive"`
+	Joins     int     `json:"joins"`
+	Leaves    int     `json:"leaves"`
+	Failures  int     `json:"failures"`
+	Lookups   int     `json:"lookups"`
+	Succeeded int     `json:"succeeded"`
+	MeanHops  float64 `json:"mean_hops"`
+	Converged bool    `json:"converged"`
+	// KeysUnreachable counts keys which Get could not return at the tick.
+	KeysUnreachable int `json:"keys_unreachable"`
+}
+
+// Report is the result of a simulation.
+type Report struct {
+	Nodes     int      `json:"nodes"`
+	Ticks     int      `json:"ticks"`
+	Arrival   string   `json:"arrival"`
+	Session   string   `json:"session"`
+	FailRatio float64  `json:"fail_ratio"`
+	Samples   []Sample `json:"samples"`
+	// LookupSuccess is the ratio of lookups answered by the right node.
+	LookupSuccess float64 `json:"lookup_success"`
+	// Hops[i] is the number of successful lookups which took i hops.
+	Hops []int `json:"hops"`
+	// Convergence lists ticks from the first churn event to a consistent ring.
+	Convergence []int `json:"convergence"`
+	// Settled is false when the ring did not converge after churn.
+	Settled bool `json:"settled"`
+	Keys    int  `json:"keys"`
+	// KeysLost counts keys missing after the ring settled.
+	KeysLost int `json:"keys_lost"`
+	// Merge is the number of ticks from healing the partition to a consistent ring,
+	// at least 1. -1 means the ring did not converge; nil means no partition healed.
+	Merge *int `json:"merge,omitempty"`
+}
+
+// Hash is the hash of simulated nodes.
+// Addresses are hex ids and keys are hashed by FNV-1a.
+func Hash(k string) uint64 {
+	if u, err := strconv.ParseUint(k, 16, 64); err == nil {
+		return u
+	}
+	h := fnv.New64a()
+	h.Write([]byte(k))
+	return h.Sum64()
+}
+
+// Simulation runs a Config.
+type Simulation struct {
+	c Config
+	r *rand.Rand
+
+	nodes   map[uint64]*chord.Node
+	net     *Network
+	alive   []uint64 // sorted
+	leaveAt map[uint64]int
+
+	nextJoin float64
+	keys     map[string]string
+	keyOrder []string
+
+	disruptedAt int
+	healedAt    int
+	report      Report
+	window      Sample
+	hops        []int
+	lookups     int
+	succeeded   int
+}
+
+// New creates Simulation.
+func New(c Config) (*Simulation, error) {
+	if c.Nodes <= 0 {
+		return nil, fmt.Errorf("initial ring of %d nodes", c.Nodes)
+	}
+	for _, d := range []Distribution{c.Arrival, c.Session} {
+		if d == nil {
+			continue
+		}
+		if err := checkDistribution(d); err != nil {
+			return nil, err
+		}
+	}
+	if c.SampleEvery <= 0 {
+		c.SampleEvery = 10
+	}
+	if c.Settle <= 0 {
+		c.Settle = 1000
+	}
+	return &Simulation{
+		c:           c,
+		r:           rand.New(rand.NewSource(c.Seed)),
+		nodes:       map[uint64]*chord.Node{},
+		net:         NewNetwork(),
+		leaveAt:     map[uint64]int{},
+		keys:        map[string]string{},
+		disruptedAt: -1,
+		healedAt:    -1,
+	}, nil
+}
+
+// Run runs the whole scenario.
+func (s *Simulation) Run() *Report {
+	s.report = Report{
+		Nodes: s.c.Nodes, Ticks: s.c.Ticks,
+		Arrival: describe(s.c.Arrival), Session: describe(s.c.Session),
+		FailRatio: s.c.FailRatio,
+		Samples:   []Sample{}, Hops: []int{}, Convergence: []int{},
+	}
+	for len(s.alive) < s.c.Nodes {
+		s.join(0)
+	}
+	s.settle()
+	for i := 0; i < s.c.Keys; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		v := strconv.Itoa(s.r.Int())
+		if err := s.randomNode().Put(k, bytes.NewBufferString(v)); err == nil {
+			s.keys[k] = v
+			s.keyOrder = append(s.keyOrder, k)
+		}
+	}
+	s.report.Keys = len(s.keys)
+	if s.c.Arrival != nil {
+		s.nextJoin = s.c.Arrival.Sample(s.r)
+	}
+	for t := 0; t < s.c.Ticks; t++ {
+		s.tick(t)
+	}
+	s.report.Settled = s.settle()
+	s.report.KeysLost = s.unreachableKeys()
+	if s.lookups > 0 {
+		s.report.LookupSuccess = float64(s.succeeded) / float64(s.lookups)
+	}
+	return &s.report
+}
+
+func (s *Simulation) tick(t int) {
+	if s.c.PartitionRatio > 0 {
+		switch t {
+		case s.c.PartitionAt:
+			for _, id := range s.alive {
+				if s.r.Float64() < s.c.PartitionRatio {
+					s.net.Place(s.nodes[id].Addr(), 1)
+				}
+			}
+		case s.c.PartitionAt + s.c.PartitionFor:
+			s.net.Heal()
+			s.healedAt = t
+			never := -1
+			s.report.Merge = &never
+			s.disruptedAt = t
+		}
+	}
+	for s.c.Arrival != nil && s.nextJoin <= float64(t) {
+		s.join(t)
+		s.window.Joins++
+		s.disrupt(t)
+		s.nextJoin += s.c.Arrival.Sample(s.r)
+	}
+	for _, id := range append([]uint64{}, s.alive...) {
+		if at, ok := s.leaveAt[id]; !ok || at > t || len(s.alive) == 1 {
+			continue
+		}
+		if s.r.Float64() < s.c.FailRatio {
+			s.nodes[id].Fail()
+			s.window.Failures++
+		} else {
+			s.nodes[id].Leave()
+			s.window.Leaves++
+		}
+		s.remove(id)
+		s.disrupt(t)
+	}
+	s.maintain()
+	converged := s.converged()
+	if converged && s.healedAt >= 0 {
+		merge := t - s.healedAt + 1
+		s.report.Merge = &merge
+		s.healedAt = -1
+	}
+	if converged && s.disruptedAt >= 0 {
+		s.report.Convergence = append(s.report.Convergence, t-s.disruptedAt+1)
+		s.disruptedAt = -1
+	}
+	for i := 0; i < s.c.LookupsPerTick; i++ {
+		s.lookup()
+	}
+	if (t+1)%s.c.SampleEvery == 0 || t == s.c.Ticks-1 {
+		s.window.Tick = t
+		s.window.Alive = len(s.alive)
+		s.window.Converged = converged
+		s.window.KeysUnreachable = s.unreachableKeys()
+		if s.window.Succeeded > 0 {
+			s.window.MeanHops /= float64(s.window.Succeeded)
+		}
+		s.report.Samples = append(s.report.Samples, s.window)
+		s.window = Sample{}
+	}
+}
+
+func (s *Simulation) disrupt(t int) {
+	if s.disruptedAt < 0 {
+		s.disruptedAt = t
+	}
+}
+
+// settle maintains the ring without churn until it converges.
+func (s *Simulation) settle() bool {
+	for i := 0; i < s.c.Settle; i++ {
+		if s.converged() {
+			if s.healedAt >= 0 {
+				merge := s.c.Ticks - s.healedAt + i
+				s.report.Merge = &merge
+				s.healedAt = -1
+			}
+			return true
+		}
+		s.maintain()
+	}
+	return s.converged()
+}
+
+func (s *Simulation) maintain() {
+	for _, id := range s.alive {
+		s.nodes[id].Maintain()
+	}
+}
+
+func (s *Simulation) join(t int) {
+	id := s.r.Uint64()
+	if _, ok := s.nodes[id]; ok {
+		return
+	}
+	n := chord.NewNode(fmt.Sprintf("%x", id), 62, Hash)
+	n.SetTransport(s.net)
+	if s.c.Replicas > 1 {
+		n.SetReplicationFactor(s.c.Replicas)
+	}
+	b := chord.Bootstrap{Resolve: s.net.Resolve, AllowCreate: len(s.alive) == 0}
+	if len(s.alive) > 0 {
+		seed := s.randomNode()
+		// a new node starts on its seed's side of partition
+		s.net.Place(n.Addr(), s.net.Side(seed.Addr()))
+		b.Discovery = []chord.Discovery{chord.StaticSeeds{seed.Addr()}}
+	}
+	if err := n.Start(b); err != nil {
+		return
+	}
+	s.net.Register(n)
+	s.nodes[id] = n
+	i := sort.Search(len(s.alive), func(i int) bool { return s.alive[i] >= id })
+	s.alive = append(s.alive, 0)
+	copy(s.alive[i+1:], s.alive[i:])
+	s.alive[i] = id
+	if s.c.Session != nil {
+		s.leaveAt[id] = t + int(s.c.Session.Sample(s.r)) + 1
+	}
+}
+
+func (s *Simulation) remove(id uint64) {
+	i := sort.Search(len(s.alive), func(i int) bool { return s.alive[i] >= id })
+	s.alive = append(s.alive[:i], s.alive[i+1:]...)
+	delete(s.leaveAt, id)
+}
+
+func (s *Simulation) randomNode() *chord.Node {
+	return s.nodes[s.alive[s.r.Intn(len(s.alive))]]
+}
+
+// owner returns the alive node responsible for k.
+func (s *Simulation) owner(k uint64) uint64 {
+	i := sort.Search(len(s.alive), func(i int) bool { return s.alive[i] >= k })
+	if i == len(s.alive) {
+		return s.alive[0]
+	}
+	return s.alive[i]
+}
+
+// converged reports whether each alive node's successor is the next alive node.
+func (s *Simulation) converged() bool {
+	for i, id := range s.alive {
+		next := s.alive[(i+1)%len(s.alive)]
+		if succ := s.nodes[id].Successor(); succ == nil || succ.ID() != next {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Simulation) lookup() {
+	k := s.r.Uint64()
+	s.lookups++
+	s.window.Lookups++
+	n, hops, err := s.randomNode().Lookup(k)
+	if err != nil || n.ID() != s.owner(k) {
+		return
+	}
+	s.succeeded++
+	s.window.Succeeded++
+	s.window.MeanHops += float64(hops)
+	for len(s.report.Hops) <= hops {
+		s.report.Hops = append(s.report.Hops, 0)
+	}
+	s.report.Hops[hops]++
+}
+
+func (s *Simulation) unreachableKeys() int {
+	lost := 0
+	for _, k := range s.keyOrder {
+		v := s.keys[k]
+		r, err := s.randomNode().Get(k)
+		if err != nil {
+			lost++
+			continue
+		}
+		b, _ := ioutil.ReadAll(r)
+		if string(b) != v {
+			lost++
+		}
+	}
+	return lost
+}
+
+func describe(d Distribution) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}
+
+// WriteJSON writes r as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
+
+// WriteCSV writes r.Samples as CSV with a header line.
+func (r *Report) WriteCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{
+		"tick", "alive", "joins", "leaves", "failures",
+		"lookups", "succeeded", "mean_hops", "converged", "keys_unreachable",
+	})
+	for _, s := range r.Samples {
+		c.Write([]string{
+			strconv.Itoa(s.Tick), strconv.Itoa(s.Alive),
+			strconv.Itoa(s.Joins), strconv.Itoa(s.Leaves), strconv.Itoa(s.Failures),
+			strconv.Itoa(s.Lookups), strconv.Itoa(s.Succeeded),
+			strconv.FormatFloat(s.MeanHops, 'f', 3, 64),
+			strconv.FormatBool(s.Converged), strconv.Itoa(s.KeysUnreachable),
+		})
+	}
+	c.Flush()
+	return c.Error()
+}
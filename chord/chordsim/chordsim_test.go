@@ -0,0 +1,150 @@
+package chordsim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+)
+
+func TestSimulation(t *testing.T) {
+	type test struct {
+		title  string
+		config Config
+		// lost keys are expected only when nodes crash
+		lossless bool
+	}
+	for _, set := range []test{
+		test{
+			title:    "stable ring",
+			config:   Config{Nodes: 50, Ticks: 50, Keys: 100, LookupsPerTick: 10, Seed: 1},
+			lossless: true,
+		},
+		test{
+			title: "joins and graceful leaves",
+			config: Config{
+				Nodes: 50, Ticks: 200, Keys: 100, LookupsPerTick: 10, Seed: 2,
+				Arrival: Exponential{Mean: 5}, Session: Exponential{Mean: 300},
+			},
+			lossless: true,
+		},
+		test{
+			title: "crashes",
+			config: Config{
+				Nodes: 50, Ticks: 200, Keys: 100, LookupsPerTick: 10, Seed: 3,
+				Arrival: Exponential{Mean: 5}, Session: Pareto{Shape: 1.5, Scale: 100}, FailRatio: 1,
+			},
+		},
+		test{
+			title: "crashes with replicas",
+			config: Config{
+				Nodes: 50, Ticks: 200, Keys: 100, LookupsPerTick: 10, Seed: 3,
+				Arrival: Exponential{Mean: 5}, Session: Pareto{Shape: 1.5, Scale: 100}, FailRatio: 1,
+				Replicas: 3,
+			},
+			lossless: true,
+		},
+	} {
+		t.Run(set.title, func(t *testing.T) {
+			r := run(t, set.config)
+			if r.Keys != set.config.Keys {
+				t.Errorf("%d keys were stored; expected %d", r.Keys, set.config.Keys)
+			}
+			if len(r.Samples) != set.config.Ticks/10 {
+				t.Errorf("%d samples were taken", len(r.Samples))
+			}
+			if set.lossless && (r.KeysLost != 0 || !r.Settled) {
+				t.Errorf("keys were lost: %+v", r)
+			}
+			if r.Settled && r.Samples[0].Joins == 0 && r.LookupSuccess != 1 {
+				t.Errorf("lookups failed on stable ring: %+v", r)
+			}
+		})
+	}
+}
+
+func run(t *testing.T, c Config) *Report {
+	t.Helper()
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Run()
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, c := range []Config{
+		{Nodes: 0, Ticks: 10},
+		{Nodes: -1, Ticks: 10},
+		{Nodes: 10, Ticks: 10, Arrival: Constant{0}},
+		{Nodes: 10, Ticks: 10, Session: Exponential{-1}},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+}
+
+func TestReportOutput(t *testing.T) {
+	r := run(t, Config{Nodes: 10, Ticks: 30, Keys: 10, LookupsPerTick: 5, Seed: 1})
+	buf := bytes.NewBuffer(nil)
+	if err := r.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := Report{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Samples) != 3 || decoded.Keys != 10 || decoded.Merge != nil {
+		t.Errorf("unexpected report %+v", decoded)
+	}
+	buf.Reset()
+	if err := r.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || rows[0][0] != "tick" || rows[1][0] != "9" {
+		t.Errorf("unexpected csv %v", rows)
+	}
+}
+
+func TestParseDistribution(t *testing.T) {
+	for _, s := range []string{"const:3", "exp:10", "pareto:1.5:20", "uniform:1:5"} {
+		d, err := ParseDistribution(s)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+		} else if d.String() != s {
+			t.Errorf("%s was parsed as %s", s, d)
+		}
+	}
+	for _, s := range []string{"", "exp", "exp:a", "pareto:1", "normal:1:2",
+		"const:0", "exp:0", "exp:-1", "pareto:0:1", "pareto:1.5:-1", "uniform:3:3", "uniform:-1:1"} {
+		if _, err := ParseDistribution(s); err == nil {
+			t.Errorf("%q was accepted", s)
+		}
+	}
+}
+
+func TestPartitionMerge(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		r := run(t, Config{
+			Nodes: 40, Ticks: 150, Keys: 100, Seed: seed,
+			PartitionRatio: 0.4, PartitionAt: 10, PartitionFor: 60,
+		})
+		if !r.Settled || r.Merge == nil || *r.Merge < 1 {
+			t.Errorf("seed %d: rings didn't merge: %+v", seed, r)
+			continue
+		}
+		if r.KeysLost != 0 {
+			t.Errorf("seed %d: %d keys were lost by merge", seed, r.KeysLost)
+		}
+		// sides can't see each other during partition
+		if s := r.Samples[5]; s.Converged || s.KeysUnreachable == 0 {
+			t.Errorf("seed %d: partition had no effect: %+v", seed, s)
+		}
+		t.Logf("seed %d: merged in %d ticks", seed, *r.Merge)
+	}
+}
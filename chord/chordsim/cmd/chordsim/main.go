@@ -0,0 +1,64 @@
+// Command chordsim runs a chord churn simulation and prints the report as JSON or CSV.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/masu-mi/gimmick.git/chord/chordsim"
+)
+
+func main() {
+	c := chordsim.Config{}
+	var arrival, session, format string
+	flag.IntVar(&c.Nodes, "nodes", 1000, "size of the initial ring")
+	flag.IntVar(&c.Ticks, "ticks", 1000, "length of the churn phase")
+	flag.StringVar(&arrival, "arrival", "exp:2", "ticks between joins (empty: no joins)")
+	flag.StringVar(&session, "session", "pareto:1.5:500", "ticks a node stays (empty: forever)")
+	flag.Float64Var(&c.FailRatio, "fail", 0.5, "ratio of departures which crash")
+	flag.IntVar(&c.Keys, "keys", 1000, "number of stored keys")
+	flag.IntVar(&c.Replicas, "replicas", 1, "replication factor of keys")
+	flag.IntVar(&c.LookupsPerTick, "lookups", 20, "lookups per tick")
+	flag.IntVar(&c.SampleEvery, "sample", 10, "ticks per sample")
+	flag.IntVar(&c.Settle, "settle", 1000, "maximum ticks to converge")
+	flag.Int64Var(&c.Seed, "seed", 1, "random seed")
+	flag.Float64Var(&c.PartitionRatio, "partition", 0, "ratio of nodes cut off by a partition (0: none)")
+	flag.IntVar(&c.PartitionAt, "partition-at", 100, "tick the partition starts")
+	flag.IntVar(&c.PartitionFor, "partition-for", 200, "ticks the partition lasts")
+	flag.StringVar(&format, "format", "json", "json or csv")
+	flag.Parse()
+
+	var err error
+	if arrival != "" {
+		if c.Arrival, err = chordsim.ParseDistribution(arrival); err != nil {
+			fail(err)
+		}
+	}
+	if session != "" {
+		if c.Session, err = chordsim.ParseDistribution(session); err != nil {
+			fail(err)
+		}
+	}
+	sim, err := chordsim.New(c)
+	if err != nil {
+		fail(err)
+	}
+	r := sim.Run()
+	switch format {
+	case "json":
+		err = r.WriteJSON(os.Stdout)
+	case "csv":
+		err = r.WriteCSV(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
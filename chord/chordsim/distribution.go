@@ -0,0 +1,94 @@
+package chordsim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Distribution draws durations in ticks.
+type Distribution interface {
+	Sample(r *rand.Rand) float64
+	String() string
+}
+
+// Constant always returns Value.
+type Constant struct{ Value float64 }
+
+// Exponential has mean Mean; arrivals of a Poisson process.
+type Exponential struct{ Mean float64 }
+
+// Pareto has shape Shape and minimum Scale; heavy-tailed session times.
+type Pareto struct{ Shape, Scale float64 }
+
+// Uniform is uniform over [Min, Max).
+type Uniform struct{ Min, Max float64 }
+
+func (d Constant) Sample(r *rand.Rand) float64    { return d.Value }
+func (d Exponential) Sample(r *rand.Rand) float64 { return r.ExpFloat64() * d.Mean }
+func (d Pareto) Sample(r *rand.Rand) float64 {
+	return d.Scale / math.Pow(1-r.Float64(), 1/d.Shape)
+}
+func (d Uniform) Sample(r *rand.Rand) float64 { return d.Min + r.Float64()*(d.Max-d.Min) }
+
+func (d Constant) String() string    { return fmt.Sprintf("const:%g", d.Value) }
+func (d Exponential) String() string { return fmt.Sprintf("exp:%g", d.Mean) }
+func (d Pareto) String() string      { return fmt.Sprintf("pareto:%g:%g", d.Shape, d.Scale) }
+func (d Uniform) String() string     { return fmt.Sprintf("uniform:%g:%g", d.Min, d.Max) }
+
+// ParseDistribution parses the String form of distributions,
+// e.g. "exp:10", "pareto:1.5:20", "uniform:1:5" or "const:3".
+func ParseDistribution(s string) (Distribution, error) {
+	parts := strings.Split(s, ":")
+	args := make([]float64, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return nil, fmt.Errorf("distribution %q: %s", s, err)
+		}
+		args = append(args, f)
+	}
+	arity := map[string]int{"const": 1, "exp": 1, "pareto": 2, "uniform": 2}
+	if n, ok := arity[parts[0]]; !ok || n != len(args) {
+		return nil, fmt.Errorf("unknown distribution %q", s)
+	}
+	var d Distribution
+	switch parts[0] {
+	case "const":
+		d = Constant{args[0]}
+	case "exp":
+		d = Exponential{args[0]}
+	case "pareto":
+		d = Pareto{args[0], args[1]}
+	default:
+		d = Uniform{args[0], args[1]}
+	}
+	if err := checkDistribution(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// checkDistribution rejects parameters which draw no positive durations;
+// arrivals of zero ticks would never let a tick end.
+func checkDistribution(d Distribution) error {
+	var ok bool
+	switch d := d.(type) {
+	case Constant:
+		ok = d.Value > 0
+	case Exponential:
+		ok = d.Mean > 0
+	case Pareto:
+		ok = d.Shape > 0 && d.Scale > 0
+	case Uniform:
+		ok = d.Min >= 0 && d.Max > d.Min
+	default:
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("distribution %s draws non-positive durations", d)
+	}
+	return nil
+}
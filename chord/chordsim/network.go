@@ -0,0 +1,44 @@
+package chordsim
+
+import (
+	"time"
+
+	"github.com/masu-mi/gimmick.git/chord"
+)
+
+// Network connects simulated nodes.
+// It resolves addresses and cuts routes between sides of a partition.
+type Network struct {
+	chord.Registry
+	side map[string]int
+}
+
+// NewNetwork creates Network without partition.
+func NewNetwork() *Network {
+	return &Network{side: map[string]int{}}
+}
+
+// Place puts the node at addr on a side of partition.
+func (w *Network) Place(addr string, side int) {
+	w.side[addr] = side
+}
+
+// Side returns the side of partition addr is on.
+func (w *Network) Side(addr string) int {
+	return w.side[addr]
+}
+
+// Heal puts every node back on one side.
+func (w *Network) Heal() {
+	w.side = map[string]int{}
+}
+
+// Reachable reports whether from and to are on the same side.
+func (w *Network) Reachable(from, to string) bool {
+	return w.side[from] == w.side[to]
+}
+
+// RTT is unknown on simulated network.
+func (w *Network) RTT(from, to string) (time.Duration, bool) {
+	return 0, false
+}
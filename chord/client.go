@@ -0,0 +1,300 @@
+package chord
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Consistency is how many replicas a request waits for.
+type Consistency int
+
+const (
+	// One waits for a single replica.
+	One Consistency = iota + 1
+	// Quorum waits for a majority of replicas, or Client.R and Client.W.
+	Quorum
+	// All waits for every replica.
+	All
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case One:
+		return "ONE"
+	case Quorum:
+		return "QUORUM"
+	case All:
+		return "ALL"
+	}
+	return "Consistency(?)"
+}
+
+var ErrUnavailable = errors.New("not enough replicas answered")
+
+// maxHints bounds hints a node keeps for unreachable replicas.
+const maxHints = 1024
+
+// hint is a write kept for a replica which was unreachable.
+type hint struct {
+	to  *Node
+	key string
+	e   entry
+}
+
+// Client reads and writes keys through Node at a chosen consistency.
+type Client struct {
+	Node *Node
+	// R and W are replicas Get and Put wait for at Quorum.
+	// Zero means a majority of the replication factor.
+	R, W int
+	// WatchInterval is how often watches check owners of their keys.
+	// Zero means 1 second.
+	WatchInterval time.Duration
+}
+
+var _ StorageService = (*Client)(nil)
+
+// Put writes value at Quorum.
+func (c *Client) Put(key string, value io.Reader) error {
+	return c.PutAt(key, value, Quorum)
+}
+
+// Get reads value at Quorum.
+func (c *Client) Get(key string) (io.ReadCloser, error) {
+	return c.GetAt(key, Quorum)
+}
+
+// PutTTL writes value which expires after ttl at Quorum.
+func (c *Client) PutTTL(key string, value io.Reader, ttl time.Duration) error {
+	return c.PutTTLAt(key, value, ttl, Quorum)
+}
+
+// PutAt writes value to the replicas of key and waits for l of them.
+// Writes to unreachable replicas are kept as hints by Node and
+// handed off once they are back; hints don't count for l.
+func (c *Client) PutAt(key string, value io.Reader, l Consistency) error {
+	return c.PutTTLAt(key, value, 0, l)
+}
+
+// PutTTLAt is PutAt of value which expires after ttl. Zero ttl means it never expires.
+// Negative ttl is ErrInvalidTTL.
+func (c *Client) PutTTLAt(key string, value io.Reader, ttl time.Duration, l Consistency) error {
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+	b, err := ioutil.ReadAll(value)
+	if err != nil {
+		return err
+	}
+	return c.write(key, newEntry(b, ttl), l)
+}
+
+// Delete deletes key at Quorum.
+func (c *Client) Delete(key string) error {
+	return c.DeleteAt(key, Quorum)
+}
+
+// DeleteAt deletes key on its replicas and waits for l of them.
+func (c *Client) DeleteAt(key string, l Consistency) error {
+	return c.write(key, newTombstone(), l)
+}
+
+func (c *Client) write(key string, e entry, l Consistency) error {
+	rs, err := c.replicas(key)
+	if err != nil {
+		return err
+	}
+	return c.replicate(key, e, rs, 0, l)
+}
+
+// replicate writes e to rs and waits for l of them counting acks already taken.
+func (c *Client) replicate(key string, e entry, rs []*Node, acks int, l Consistency) error {
+	need := c.needed(l, c.W, len(rs)+acks)
+	for _, r := range rs {
+		if c.Node.unreachable(r) {
+			c.Node.addHint(hint{to: r, key: key, e: e})
+			continue
+		}
+		r.storeEntry(key, e)
+		acks++
+	}
+	if acks < need {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// Update replaces the value of key by fn's result atomically on the owner
+// of key and writes it to the other replicas at Quorum. ok is false when
+// key is not found. An error of fn aborts the update and is returned.
+// The new value expires when the old one does.
+// fn runs while the owner's storage is locked; it must not use the ring.
+func (c *Client) Update(key string, fn func(value []byte, ok bool) ([]byte, error)) error {
+	rs, err := c.replicas(key)
+	if err != nil {
+		return err
+	}
+	o := rs[0]
+	if c.Node.unreachable(o) {
+		return ErrUnavailable
+	}
+	// the owner may have taken over the key just now
+	for _, r := range rs[1:] {
+		if e, ok := r.loadEntry(key); ok && !c.Node.unreachable(r) {
+			o.storeEntry(key, e)
+		}
+	}
+	e, err := o.updateEntry(key, fn)
+	if err != nil {
+		return err
+	}
+	return c.replicate(key, e, rs[1:], 1, Quorum)
+}
+
+// GetAt reads key from l replicas and returns the newest value.
+// Replicas answering older values are repaired on the way.
+// An expired newest value is not found.
+func (c *Client) GetAt(key string, l Consistency) (io.ReadCloser, error) {
+	rs, err := c.replicas(key)
+	if err != nil {
+		return nil, err
+	}
+	need := c.needed(l, c.R, len(rs))
+	type answer struct {
+		r  *Node
+		e  entry
+		ok bool
+	}
+	var answers []answer
+	var newest entry
+	found := false
+	for _, r := range rs {
+		if len(answers) >= need {
+			break
+		}
+		if c.Node.unreachable(r) {
+			continue
+		}
+		e, ok := r.loadEntry(key)
+		answers = append(answers, answer{r, e, ok})
+		if ok && (!found || e.newer(newest)) {
+			newest, found = e, true
+		}
+	}
+	if len(answers) < need {
+		return nil, ErrUnavailable
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	for _, a := range answers {
+		if !a.ok || newest.newer(a.e) {
+			a.r.storeEntry(key, newest)
+		}
+	}
+	if !newest.live(now()) {
+		return nil, ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(newest.value)), nil
+}
+
+// replicas returns the owner of key and its successors up to the replication factor,
+// reachable or not.
+func (c *Client) replicas(key string) ([]*Node, error) {
+	o, _, err := c.Node.Lookup(c.Node.Hash(key))
+	if err != nil {
+		return nil, err
+	}
+	rs := []*Node{o}
+	for _, s := range o.succs() {
+		if len(rs) >= o.replicas {
+			break
+		}
+		if s != nil && s != o && !containsNode(rs, s) {
+			rs = append(rs, s)
+		}
+	}
+	return rs, nil
+}
+
+func containsNode(ns []*Node, n *Node) bool {
+	for _, m := range ns {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// needed returns the number of replicas l waits for out of n.
+func (c *Client) needed(l Consistency, quorum, n int) int {
+	switch l {
+	case One:
+		return 1
+	case All:
+		return n
+	}
+	if quorum > 0 && quorum <= n {
+		return quorum
+	}
+	return n/2 + 1
+}
+
+func (n *Node) updateEntry(key string, fn func([]byte, bool) ([]byte, error)) (entry, error) {
+	n.m.Lock()
+	old, ok := n.data[key]
+	ok = ok && old.live(now())
+	if !ok {
+		old.value = nil
+	}
+	v, err := fn(old.value, ok)
+	if err != nil {
+		n.m.Unlock()
+		return entry{}, err
+	}
+	e := newEntry(v, 0)
+	if ok {
+		e.expire = old.expire
+	}
+	if n.data == nil {
+		n.data = map[string]entry{}
+	}
+	n.data[key] = e
+	n.version++
+	n.m.Unlock()
+	n.keyChanged(key, e)
+	return e, nil
+}
+
+func (n *Node) addHint(h hint) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if len(n.hints) >= maxHints {
+		n.hints = n.hints[1:]
+	}
+	n.hints = append(n.hints, h)
+}
+
+// handoff delivers hints to replicas which became reachable.
+func (n *Node) handoff() {
+	n.m.Lock()
+	var ready, waiting []hint
+	for _, h := range n.hints {
+		switch {
+		case h.to.fail():
+			// it won't come back; anti-entropy restores the replication factor
+		case n.unreachable(h.to):
+			waiting = append(waiting, h)
+		default:
+			ready = append(ready, h)
+		}
+	}
+	n.hints = waiting
+	n.m.Unlock()
+	for _, h := range ready {
+		h.to.storeEntry(h.key, h.e)
+	}
+}
@@ -0,0 +1,103 @@
+package chord
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cut is a transport which lost routes to some addresses.
+type cut map[string]bool
+
+func (t cut) RTT(from, to string) (time.Duration, bool) { return 0, false }
+func (t cut) Reachable(from, to string) bool            { return !t[to] }
+
+func replicatedRing() []*Node {
+	ring := generateNodes(4, 0, 16)
+	setupRingStatically(ring, 3)
+	for _, n := range ring {
+		n.SetReplicationFactor(3)
+	}
+	return ring
+}
+
+func TestClientConsistency(t *testing.T) {
+	ring := replicatedRing()
+	// ring[0] coordinates; key 5 is stored on ring[1], ring[2] and ring[3]
+	network := cut{}
+	ring[0].SetTransport(network)
+	c := &Client{Node: ring[0]}
+
+	if err := c.PutAt("5", strings.NewReader("v1"), All); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range ring[1:] {
+		if b, _ := r.loadLocal("5"); string(b) != "v1" {
+			t.Errorf("id:%d has %q", r.id, b)
+		}
+	}
+
+	network[ring[3].addr] = true
+	if err := c.PutAt("5", strings.NewReader("v2"), All); err != ErrUnavailable {
+		t.Errorf("ALL succeeded without a replica: %v", err)
+	}
+	if err := c.PutAt("5", strings.NewReader("v3"), Quorum); err != nil {
+		t.Errorf("QUORUM failed with 2 of 3 replicas: %s", err)
+	}
+	if err := (&Client{Node: ring[0], W: 3}).Put("5", strings.NewReader("v4")); err != ErrUnavailable {
+		t.Errorf("W=3 succeeded with 2 replicas: %v", err)
+	}
+	if _, err := c.GetAt("5", All); err != ErrUnavailable {
+		t.Errorf("ALL read succeeded without a replica: %v", err)
+	}
+	r, err := c.GetAt("5", One)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(r); string(b) != "v4" {
+		t.Errorf("ONE read %q", b)
+	}
+
+	// hinted handoff delivers the writes ring[3] missed
+	ring[0].handoff()
+	if b, _ := ring[3].loadLocal("5"); string(b) != "v1" {
+		t.Errorf("hints were delivered to unreachable replica: %q", b)
+	}
+	delete(network, ring[3].addr)
+	ring[0].handoff()
+	if b, _ := ring[3].loadLocal("5"); string(b) != "v4" {
+		t.Errorf("hints were not handed off: %q", b)
+	}
+	if len(ring[0].hints) != 0 {
+		t.Errorf("%d hints are left", len(ring[0].hints))
+	}
+}
+
+func TestClientReadRepair(t *testing.T) {
+	ring := replicatedRing()
+	c := &Client{Node: ring[0]}
+	c.PutAt("5", strings.NewReader("new"), All)
+	ring[2].m.Lock()
+	ring[2].data["5"] = entry{value: []byte("old"), stamp: 1}
+	ring[2].m.Unlock()
+	ring[3].m.Lock()
+	delete(ring[3].data, "5")
+	ring[3].m.Unlock()
+
+	r, err := c.GetAt("5", All)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(r); string(b) != "new" {
+		t.Errorf("stale value was returned: %q", b)
+	}
+	for _, n := range ring[1:] {
+		if b, _ := n.loadLocal("5"); string(b) != "new" {
+			t.Errorf("id:%d was not repaired: %q", n.id, b)
+		}
+	}
+	if _, err := c.GetAt("6", One); err != ErrNotFound {
+		t.Errorf("missing key returned %v", err)
+	}
+}
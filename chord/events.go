@@ -0,0 +1,157 @@
+package chord
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType is the kind of Event.
+type EventType int
+
+const (
+	PredecessorChanged EventType = iota
+	SuccessorChanged
+	FingerChanged
+	FailureDetected
+	KeysTransferred
+	RingMerged
+)
+
+func (t EventType) String() string {
+	switch t {
+	case PredecessorChanged:
+		return "predecessor changed"
+	case SuccessorChanged:
+		return "successor changed"
+	case FingerChanged:
+		return "finger changed"
+	case FailureDetected:
+		return "failure detected"
+	case KeysTransferred:
+		return "keys transferred"
+	case RingMerged:
+		return "ring merged"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a change of node state.
+// From and To are addresses; empty means no node.
+type Event struct {
+	Type EventType
+	Time time.Time
+	Node string
+	From string
+	To   string
+	// Finger is the index of changed finger.
+	Finger int
+	// Keys is the number of transferred keys.
+	Keys int
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case FingerChanged:
+		return fmt.Sprintf("%s: finger[%d] changed from %s to %s", e.Node, e.Finger, describeAddr(e.From), describeAddr(e.To))
+	case FailureDetected:
+		return fmt.Sprintf("%s: failure of %s detected", e.Node, e.To)
+	case KeysTransferred:
+		return fmt.Sprintf("%s: %d keys transferred to %s", e.Node, e.Keys, e.To)
+	case RingMerged:
+		return fmt.Sprintf("%s: moved to the ring of %s", e.Node, e.To)
+	}
+	return fmt.Sprintf("%s: %s from %s to %s", e.Node, e.Type, describeAddr(e.From), describeAddr(e.To))
+}
+
+func describeAddr(a string) string {
+	if a == "" {
+		return "none"
+	}
+	return a
+}
+
+// Subscribe returns a channel of n's events and a function to stop it.
+// Events are dropped while the channel's buffer of size buf is full.
+func (n *Node) Subscribe(buf int) (<-chan Event, func()) {
+	ch := make(chan Event, buf)
+	n.em.Lock()
+	if n.subscribers == nil {
+		n.subscribers = map[chan Event]struct{}{}
+	}
+	n.subscribers[ch] = struct{}{}
+	n.em.Unlock()
+	return ch, func() {
+		n.em.Lock()
+		defer n.em.Unlock()
+		if _, ok := n.subscribers[ch]; ok {
+			delete(n.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (n *Node) emit(e Event) {
+	e.Node = n.addr
+	e.Time = time.Now()
+	n.em.Lock()
+	defer n.em.Unlock()
+	for ch := range n.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func addrOf(n *Node) string {
+	if n == nil {
+		return ""
+	}
+	return n.addr
+}
+
+// setPredecessor replaces predecessor and reports the change.
+func (n *Node) setPredecessor(p *Node) {
+	n.rm.Lock()
+	old := n.predecessor
+	n.predecessor = p
+	n.rm.Unlock()
+	if old == p {
+		return
+	}
+	e := Event{Type: PredecessorChanged, From: addrOf(old), To: addrOf(p)}
+	n.metric().PredecessorChanged()
+	n.emit(e)
+	// n's arc changed; watchers look for the owners again
+	n.keysMoved()
+}
+
+// setSuccessors replaces successor list and reports the change of the first entry.
+func (n *Node) setSuccessors(ss []*Node) {
+	old := n.Successor()
+	n.rm.Lock()
+	n.successors = ss
+	n.rm.Unlock()
+	for _, s := range ss {
+		if s != nil && s != n {
+			n.Remember(s.addr)
+		}
+	}
+	if s := n.Successor(); s != old {
+		n.metric().SuccessorChanged()
+		n.emit(Event{Type: SuccessorChanged, From: addrOf(old), To: addrOf(s)})
+	}
+}
+
+func (n *Node) failureDetected(f *Node) {
+	n.metric().FailureDetected()
+	n.emit(Event{Type: FailureDetected, To: addrOf(f)})
+}
+
+func (n *Node) keysTransferred(to *Node, count int) {
+	if count == 0 {
+		return
+	}
+	n.metric().KeysTransferred(count)
+	n.emit(Event{Type: KeysTransferred, To: addrOf(to), Keys: count})
+}
@@ -0,0 +1,118 @@
+package chord
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func setClock(t time.Time) func(time.Duration) {
+	now = func() time.Time { return t }
+	return func(d time.Duration) { t = t.Add(d) }
+}
+
+func TestExpiry(t *testing.T) {
+	defer func() { now = time.Now }()
+	advance := setClock(time.Unix(1000, 0))
+
+	ring := replicatedRing()
+	c := &Client{Node: ring[0]}
+	// ring[3] missed the write and keeps an old value
+	ring[3].storeEntry("5", entry{value: []byte("stale"), stamp: 1})
+	network := cut{ring[3].addr: true}
+	ring[0].SetTransport(network)
+	if err := c.PutTTLAt("5", strings.NewReader("v"), time.Minute, Quorum); err != nil {
+		t.Fatal(err)
+	}
+	ring[0].hints = nil
+	delete(network, ring[3].addr)
+	if _, err := c.GetAt("5", One); err != nil {
+		t.Errorf("key expired early: %s", err)
+	}
+
+	advance(2 * time.Minute)
+	if _, err := c.GetAt("5", One); err != ErrNotFound {
+		t.Errorf("expired key is visible: %v", err)
+	}
+	if _, err := ring[0].Get("5"); err != ErrNotFound {
+		t.Errorf("expired key is visible through Node: %v", err)
+	}
+	for _, n := range ring {
+		n.collectExpired()
+	}
+	if e, _ := ring[1].loadEntry("5"); !e.tomb || e.value != nil {
+		t.Errorf("expired key was not collected: %+v", e)
+	}
+	// anti-entropy spreads the tombstone instead of the stale value
+	ring[1].antiEntropy()
+	ring[1].antiEntropy()
+	for _, n := range ring[1:] {
+		if e, _ := n.loadEntry("5"); !e.tomb {
+			t.Errorf("id:%d has %+v", n.id, e)
+		}
+	}
+	if _, err := c.GetAt("5", All); err != ErrNotFound {
+		t.Errorf("stale replica brought the key back: %v", err)
+	}
+
+	advance(tombstoneGrace)
+	for _, n := range ring {
+		n.collectExpired()
+		if _, ok := n.loadEntry("5"); ok {
+			t.Errorf("id:%d keeps tombstone after grace", n.id)
+		}
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	ring := replicatedRing()
+	c := &Client{Node: ring[0]}
+	if err := c.PutTTL("5", strings.NewReader("v"), -time.Second); err != ErrInvalidTTL {
+		t.Errorf("client stored a key of negative ttl: %v", err)
+	}
+	if err := ring[0].PutTTL("5", strings.NewReader("v"), -time.Second); err != ErrInvalidTTL {
+		t.Errorf("node stored a key of negative ttl: %v", err)
+	}
+	if _, err := c.Get("5"); err != ErrNotFound {
+		t.Errorf("key of negative ttl is visible: %v", err)
+	}
+}
+
+func TestUpdateKeepsExpiry(t *testing.T) {
+	defer func() { now = time.Now }()
+	advance := setClock(time.Unix(1000, 0))
+
+	c := &Client{Node: replicatedRing()[0]}
+	if err := c.PutTTL("5", strings.NewReader("v1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	advance(30 * time.Second)
+	if err := c.Update("5", func(v []byte, ok bool) ([]byte, error) {
+		return []byte("v2"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Minute)
+	if _, err := c.Get("5"); err != ErrNotFound {
+		t.Errorf("updated key outlived its ttl: %v", err)
+	}
+}
+
+func TestExpirySurvivesHandoff(t *testing.T) {
+	defer func() { now = time.Now }()
+	advance := setClock(time.Unix(1000, 0))
+
+	ring := generateNodes(4, 0, 4)
+	setupRingStatically(ring, 2)
+	ring[0].PutTTL("5", strings.NewReader("v"), time.Minute)
+	before, _ := ring[2].loadEntry("5")
+	ring[2].Leave()
+	after, ok := ring[3].loadEntry("5")
+	if !ok || after.expire != before.expire {
+		t.Errorf("expiry was lost by handoff: %+v -> %+v", before, after)
+	}
+	advance(time.Minute)
+	if _, err := ring[0].Get("5"); err != ErrNotFound {
+		t.Errorf("handed off key doesn't expire: %v", err)
+	}
+}
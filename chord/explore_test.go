@@ -0,0 +1,322 @@
+package chord
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// world is a state of the explored system.
+// Nodes are referred by index so that actions apply to any clone.
+type world struct {
+	nodes    []*Node
+	member   []bool
+	pending  []notice // notify sent by updateSuccessors but not delivered
+	failures int
+}
+
+type notice struct{ to, from int }
+
+type action struct {
+	name string
+	do   func(w *world) bool // false when the action is not enabled
+}
+
+// exploreConfig bounds the explored schedules.
+type exploreConfig struct {
+	members     []uint64 // initial ring
+	joiners     []uint64
+	space       uint64
+	r           int
+	lastIndex   uint32
+	maxFailures int
+	depth       int
+	maxStates   int
+	// unsafeFailures drops the failure assumption of the protocol.
+	unsafeFailures bool
+}
+
+func newWorld(c exploreConfig) *world {
+	w := &world{}
+	var ring []*Node
+	for _, id := range append(append([]uint64{}, c.members...), c.joiners...) {
+		n := NewNode(fmt.Sprintf("%x", id), c.lastIndex, generateTestHash(c.space))
+		n.SetSuccessorListLength(c.r)
+		w.nodes = append(w.nodes, n)
+		w.member = append(w.member, len(ring) < len(c.members))
+		if len(ring) < len(c.members) {
+			ring = append(ring, n)
+		}
+	}
+	sortNodes(ring)
+	sl := c.r
+	if sl > len(ring)-1 {
+		sl = len(ring) - 1
+	}
+	setupRingStatically(ring, sl)
+	return w
+}
+
+func (w *world) index(n *Node) int {
+	for i, m := range w.nodes {
+		if m == n {
+			return i
+		}
+	}
+	return -1
+}
+
+func (w *world) clone() *world {
+	c := &world{
+		member:   append([]bool{}, w.member...),
+		pending:  append([]notice{}, w.pending...),
+		failures: w.failures,
+	}
+	for _, n := range w.nodes {
+		c.nodes = append(c.nodes, &Node{
+			addr: n.addr, id: n.id, hash: n.hash,
+			nextFinger: n.nextFinger, lastIndex: n.lastIndex, failed: n.failed,
+			succListLen: n.succListLen, candidateSize: n.candidateSize,
+		})
+	}
+	mapNode := func(n *Node) *Node {
+		if i := w.index(n); i >= 0 {
+			return c.nodes[i]
+		}
+		return nil
+	}
+	mapNodes := func(ns []*Node) []*Node {
+		if ns == nil {
+			return nil
+		}
+		out := make([]*Node, len(ns))
+		for i, n := range ns {
+			out[i] = mapNode(n)
+		}
+		return out
+	}
+	for i, n := range w.nodes {
+		m := c.nodes[i]
+		m.successors = mapNodes(n.successors)
+		m.predecessor = mapNode(n.predecessor)
+		m.finger = mapNodes(n.finger)
+		for _, cands := range n.candidates {
+			m.candidates = append(m.candidates, mapNodes(cands))
+		}
+	}
+	return c
+}
+
+func (w *world) members() []*Node {
+	var ms []*Node
+	for i, n := range w.nodes {
+		if w.member[i] {
+			ms = append(ms, n)
+		}
+	}
+	return ms
+}
+
+// key identifies a state regardless of the schedule reaching it.
+func (w *world) key() string {
+	b := strings.Builder{}
+	ids := func(ns []*Node) {
+		for _, n := range ns {
+			if n == nil {
+				b.WriteString("-,")
+			} else {
+				fmt.Fprintf(&b, "%d,", n.id)
+			}
+		}
+	}
+	for i, n := range w.nodes {
+		fmt.Fprintf(&b, "%d:%t:%t:", n.id, w.member[i], n.failed)
+		ids(n.successors)
+		b.WriteString("|")
+		ids([]*Node{n.predecessor})
+		b.WriteString("|")
+		ids(n.finger)
+		fmt.Fprintf(&b, "%d;", n.nextFinger)
+	}
+	ps := make([]string, 0, len(w.pending))
+	for _, p := range w.pending {
+		ps = append(ps, fmt.Sprintf("%d<-%d", p.to, p.from))
+	}
+	sort.Strings(ps)
+	b.WriteString(strings.Join(ps, ","))
+	return b.String()
+}
+
+func (w *world) actions(c exploreConfig) []action {
+	var as []action
+	for i, n := range w.nodes {
+		i, n := i, n
+		if !w.member[i] {
+			for j, via := range w.nodes {
+				j := j
+				if !w.member[j] || via.fail() {
+					continue
+				}
+				as = append(as, action{fmt.Sprintf("joinRing(%d via %d)", n.id, via.id), func(w *world) bool {
+					if w.nodes[i].joinRing(w.nodes[j]) != nil {
+						return false
+					}
+					w.member[i] = true
+					return true
+				}})
+			}
+			continue
+		}
+		if n.fail() {
+			continue
+		}
+		as = append(as,
+			action{fmt.Sprintf("stabilize(%d)", n.id), func(w *world) bool {
+				s := w.nodes[i].updateSuccessors()
+				if s != nil {
+					w.pending = append(w.pending, notice{to: w.index(s), from: i})
+				}
+				return true
+			}},
+			action{fmt.Sprintf("fixFigures(%d)", n.id), func(w *world) bool {
+				w.nodes[i].fixFigures()
+				return true
+			}},
+			action{fmt.Sprintf("checkPredecessor(%d)", n.id), func(w *world) bool {
+				w.nodes[i].checkPredecessor()
+				return true
+			}},
+		)
+		if w.failures < c.maxFailures {
+			as = append(as, action{fmt.Sprintf("fail(%d)", n.id), func(w *world) bool {
+				if !c.unsafeFailures && !failureAllowed(w.members(), w.nodes[i]) {
+					return false
+				}
+				w.nodes[i].Fail()
+				w.failures++
+				return true
+			}})
+		}
+	}
+	for k, p := range w.pending {
+		k := k
+		as = append(as, action{fmt.Sprintf("notify(%d by %d)", w.nodes[p.to].id, w.nodes[p.from].id), func(w *world) bool {
+			p := w.pending[k]
+			w.pending = append(w.pending[:k:k], w.pending[k+1:]...)
+			if !w.nodes[p.to].fail() {
+				w.nodes[p.to].notify(w.nodes[p.from])
+			}
+			return true
+		}})
+	}
+	return as
+}
+
+// converge delivers pending notifies and stabilizes members until
+// the ring is ideal. It reports an error when it does not converge.
+func (w *world) converge() error {
+	for _, p := range w.pending {
+		if !w.nodes[p.to].fail() {
+			w.nodes[p.to].notify(w.nodes[p.from])
+		}
+	}
+	w.pending = nil
+	ms := w.members()
+	for round := 0; round < 2*len(ms)+2; round++ {
+		if checkRingConverged(ms) == nil {
+			return nil
+		}
+		for _, n := range aliveNodes(ms) {
+			n.stabilize()
+		}
+	}
+	return checkRingConverged(ms)
+}
+
+type counterexample struct {
+	trace []string
+	err   error
+}
+
+func (c *counterexample) String() string {
+	return fmt.Sprintf("%s\n  after %d steps:\n    %s", c.err, len(c.trace), strings.Join(c.trace, "\n    "))
+}
+
+// explore visits schedules in breadth first order, so the first
+// counterexample found is a shortest one. Each reached state is checked
+// for the ring invariants and for convergence once churn stops.
+// complete is false when maxStates cut the search short.
+func explore(c exploreConfig) (ce *counterexample, states int, complete bool) {
+	type visit struct {
+		w     *world
+		trace []string
+	}
+	start := newWorld(c)
+	seen := map[string]bool{start.key(): true}
+	queue := []visit{{w: start}}
+	for len(queue) > 0 {
+		if len(seen) >= c.maxStates {
+			return nil, len(seen), false
+		}
+		v := queue[0]
+		queue = queue[1:]
+		if len(v.trace) >= c.depth {
+			continue
+		}
+		for _, a := range v.w.actions(c) {
+			next := v.w.clone()
+			if !a.do(next) {
+				continue
+			}
+			trace := append(append([]string{}, v.trace...), a.name)
+			if err := checkRingInvariants(next.members()); err != nil {
+				return &counterexample{trace: trace, err: err}, len(seen), true
+			}
+			k := next.key()
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			if err := next.clone().converge(); err != nil {
+				return &counterexample{trace: trace, err: fmt.Errorf("does not converge: %s", err)}, len(seen), true
+			}
+			queue = append(queue, visit{w: next, trace: trace})
+		}
+	}
+	return nil, len(seen), true
+}
+
+func TestExploreMaintenanceSchedules(t *testing.T) {
+	for _, c := range []exploreConfig{
+		{members: []uint64{0, 5, 10}, joiners: []uint64{3, 12}, space: 16, r: 2, lastIndex: 1, maxFailures: 1, depth: 6, maxStates: 100000},
+		{members: []uint64{1, 6, 11, 14}, joiners: []uint64{4, 8}, space: 16, r: 2, lastIndex: 1, maxFailures: 1, depth: 5, maxStates: 100000},
+		{members: []uint64{0, 8}, joiners: []uint64{2, 4, 6, 12}, space: 16, r: 3, lastIndex: 2, maxFailures: 0, depth: 6, maxStates: 100000},
+	} {
+		ce, states, complete := explore(c)
+		if ce != nil {
+			t.Errorf("ring %v joined by %v: %s", c.members, c.joiners, ce)
+		}
+		if !complete {
+			t.Errorf("ring %v joined by %v: schedules beyond %d states were not explored", c.members, c.joiners, states)
+		}
+		t.Logf("ring %v joined by %v: %d states", c.members, c.joiners, states)
+	}
+}
+
+// TestExploreReportsMinimalCounterexample drops the failure assumption:
+// r adjacent failures strand a node without alive successor.
+func TestExploreReportsMinimalCounterexample(t *testing.T) {
+	c := exploreConfig{
+		members: []uint64{0, 4, 8, 12}, space: 16, r: 2, lastIndex: 1,
+		maxFailures: 2, depth: 4, maxStates: 100000, unsafeFailures: true,
+	}
+	ce, _, _ := explore(c)
+	if ce == nil {
+		t.Fatal("no counterexample was found")
+	}
+	if len(ce.trace) != 2 || !strings.HasPrefix(ce.trace[0], "fail(") || !strings.HasPrefix(ce.trace[1], "fail(") {
+		t.Errorf("counterexample is not minimal: %s", ce)
+	}
+	t.Logf("expected counterexample: %s", ce)
+}
@@ -0,0 +1,126 @@
+package chord
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/masu-mi/gimmick.git/sets/s1"
+)
+
+func aliveNodes(nodes []*Node) []*Node {
+	var live []*Node
+	for _, n := range nodes {
+		if !n.fail() {
+			live = append(live, n)
+		}
+	}
+	return live
+}
+
+// checkRingInvariants checks safety invariants of Zave's protocol over alive nodes:
+// following the first alive successor, every node reaches exactly one ring
+// (AtLeastOneRing, AtMostOneRing, ConnectedAppendages) and the ring goes
+// around the id space exactly once (OrderedRing).
+func checkRingInvariants(nodes []*Node) error {
+	live := aliveNodes(nodes)
+	if len(live) == 0 {
+		return nil
+	}
+	for _, n := range live {
+		if n.liveSuccessor() == nil {
+			return fmt.Errorf("id:%d has no alive successor", n.id)
+		}
+	}
+	var ring []*Node
+	for _, n := range live {
+		// walk until a node repeats; the walk ends on the cycle n reaches
+		pos := map[*Node]int{}
+		var path []*Node
+		c := n
+		for ; ; c = c.liveSuccessor() {
+			if _, ok := pos[c]; ok {
+				break
+			}
+			pos[c] = len(path)
+			path = append(path, c)
+		}
+		cycle := path[pos[c]:]
+		if ring == nil {
+			ring = cycle
+		} else if !sameCycle(ring, cycle) {
+			return fmt.Errorf("more than one ring: %s and %s", describeNodes(ring), describeNodes(cycle))
+		}
+	}
+	if len(ring) > 1 {
+		ids := make([]uint64, 0, len(ring))
+		for _, n := range ring {
+			ids = append(ids, n.id)
+		}
+		if s1.RotationNumber(ids...) != 1 {
+			return fmt.Errorf("ring is not ordered: %s", describeNodes(ring))
+		}
+	}
+	return nil
+}
+
+func sameCycle(a, b []*Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	in := map[*Node]bool{}
+	for _, n := range a {
+		in[n] = true
+	}
+	for _, n := range b {
+		if !in[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRingConverged checks alive nodes form the ideal ring:
+// successor lists and predecessors point to neighbours in id order.
+func checkRingConverged(nodes []*Node) error {
+	live := aliveNodes(nodes)
+	sort.Slice(live, func(i, j int) bool { return live[i].id < live[j].id })
+	l := len(live)
+	for i, n := range live {
+		expected := n.succListLen
+		if expected > l-1 {
+			expected = l - 1
+		}
+		if expected < 1 {
+			expected = 1
+		}
+		if len(n.successors) != expected {
+			return fmt.Errorf("id:%d has successors %s; expected %d entries", n.id, describeNodes(n.successors), expected)
+		}
+		for j, s := range n.successors {
+			if s != live[(i+1+j)%l] {
+				return fmt.Errorf("id:%d has successors %s", n.id, describeNodes(n.successors))
+			}
+		}
+		if p := live[(i+l-1)%l]; n.predecessor != p {
+			return fmt.Errorf("id:%d has predecessor %s; expected id:%d", n.id, describeNodes([]*Node{n.predecessor}), p.id)
+		}
+	}
+	return nil
+}
+
+func describeNodes(nodes []*Node) string {
+	s := "["
+	for i, n := range nodes {
+		if i > 0 {
+			s += " "
+		}
+		if n == nil {
+			s += "nil"
+		} else if n.fail() {
+			s += fmt.Sprintf("id:%d(failed)", n.id)
+		} else {
+			s += fmt.Sprintf("id:%d", n.id)
+		}
+	}
+	return s + "]"
+}
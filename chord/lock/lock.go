@@ -0,0 +1,279 @@
+// Package lock provides leases on keys of a chord ring.
+//
+// A lock is a record stored under its key. The owner of the key grants a
+// lease by updating the record atomically, so the record moves with the
+// key when ownership changes and is replicated like any other key.
+// Every grant increments the fencing token of the lock; resources guarded
+// by the lock should reject tokens older than the newest they have seen,
+// e.g. with Fence. A grant reads the record from all replicas first, so a
+// new owner doesn't grant an older token than a failed owner did; the lock
+// can't be acquired while a replica is unreachable.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/masu-mi/gimmick.git/chord"
+)
+
+var (
+	ErrLocked   = errors.New("lock is held by another holder")
+	ErrNotHeld  = errors.New("lease is not held")
+	errReleased = errors.New("lease was released")
+)
+
+// now is the clock of leases.
+var now = time.Now
+
+// DefaultTTL is the length of leases of Locker without TTL.
+const DefaultTTL = 10 * time.Second
+
+// record is the stored state of a lock.
+type record struct {
+	Holder  string `json:"holder,omitempty"`
+	Token   uint64 `json:"token"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
+func (r record) held(t time.Time) bool {
+	return r.Holder != "" && t.UnixNano() < r.Expires
+}
+
+// Locker acquires leases through Client.
+type Locker struct {
+	Client *chord.Client
+	// ID identifies the holder. Empty means a random ID.
+	ID string
+	// TTL is the length of a lease. Zero means DefaultTTL.
+	// A held lease is renewed every TTL/3.
+	TTL time.Duration
+
+	once sync.Once
+}
+
+func (l *Locker) id() string {
+	l.once.Do(func() {
+		if l.ID == "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			l.ID = hex.EncodeToString(b)
+		}
+	})
+	return l.ID
+}
+
+func (l *Locker) ttl() time.Duration {
+	if l.TTL <= 0 {
+		return DefaultTTL
+	}
+	return l.TTL
+}
+
+// Lease is a time-bounded right to a lock.
+type Lease struct {
+	Key string
+	// Token is the fencing token of the lease; later leases have larger ones.
+	Token uint64
+
+	l      *Locker
+	m      sync.Mutex
+	expire time.Time
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+}
+
+// TryAcquire acquires the lock of key or returns ErrLocked at once,
+// even if l itself holds it. The lease is renewed until ctx is done
+// or Release is called, and released then. It returns
+// chord.ErrUnavailable when a replica of key is unreachable.
+func (l *Locker) TryAcquire(ctx context.Context, key string) (*Lease, error) {
+	// reading at All repairs every replica, the owner included, to the newest record
+	if r, err := l.Client.GetAt(key, chord.All); err == nil {
+		r.Close()
+	} else if err != chord.ErrNotFound {
+		return nil, err
+	}
+	var granted record
+	err := l.Client.Update(key, func(v []byte, ok bool) ([]byte, error) {
+		r := record{}
+		if ok {
+			if err := json.Unmarshal(v, &r); err != nil {
+				return nil, err
+			}
+		}
+		t := now()
+		if r.held(t) {
+			return nil, ErrLocked
+		}
+		granted = record{Holder: l.id(), Token: r.Token + 1, Expires: t.Add(l.ttl()).UnixNano()}
+		return json.Marshal(granted)
+	})
+	if err != nil && err != chord.ErrUnavailable {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Lease{
+		Key: key, Token: granted.Token, l: l,
+		expire: time.Unix(0, granted.Expires), done: make(chan struct{}), cancel: cancel,
+	}
+	if err == chord.ErrUnavailable {
+		// the owner granted it but too few replicas keep it
+		s.release()
+		cancel()
+		return nil, err
+	}
+	go s.keep(ctx)
+	return s, nil
+}
+
+// Acquire waits for the lock of key until ctx is done.
+func (l *Locker) Acquire(ctx context.Context, key string) (*Lease, error) {
+	wctx, stop := context.WithCancel(ctx)
+	defer stop()
+	w, err := l.Client.Watch(wctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		s, err := l.TryAcquire(ctx, key)
+		if err != ErrLocked {
+			return s, err
+		}
+		// wait for a release or the expiry of the current lease
+		retry := time.NewTimer(l.ttl() / 3)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return nil, ctx.Err()
+		case <-w:
+		case <-retry.C:
+		}
+		retry.Stop()
+	}
+}
+
+// keep renews s until ctx is done and releases it then.
+func (s *Lease) keep(ctx context.Context) {
+	t := time.NewTicker(s.l.ttl() / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.finish(s.release())
+			return
+		case <-t.C:
+			err := s.renew()
+			if err == chord.ErrUnavailable && now().Before(s.Expires()) {
+				// retry while the lease lasts
+				continue
+			}
+			if err != nil {
+				s.finish(err)
+				return
+			}
+		}
+	}
+}
+
+// update changes the record of s while s holds it.
+func (s *Lease) update(change func(r *record, t time.Time)) error {
+	return s.l.Client.Update(s.Key, func(v []byte, ok bool) ([]byte, error) {
+		r := record{}
+		if ok {
+			if err := json.Unmarshal(v, &r); err != nil {
+				return nil, err
+			}
+		}
+		t := now()
+		if !r.held(t) || r.Holder != s.l.id() || r.Token != s.Token {
+			return nil, ErrNotHeld
+		}
+		change(&r, t)
+		return json.Marshal(r)
+	})
+}
+
+func (s *Lease) renew() error {
+	return s.update(func(r *record, t time.Time) {
+		r.Expires = t.Add(s.l.ttl()).UnixNano()
+		s.m.Lock()
+		s.expire = time.Unix(0, r.Expires)
+		s.m.Unlock()
+	})
+}
+
+func (s *Lease) release() error {
+	err := s.update(func(r *record, t time.Time) {
+		// the token stays for the next holder
+		r.Holder, r.Expires = "", 0
+	})
+	if err == nil {
+		return errReleased
+	}
+	return err
+}
+
+func (s *Lease) finish(err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	s.err = err
+	close(s.done)
+}
+
+// Release releases s and stops renewing it.
+func (s *Lease) Release() error {
+	s.cancel()
+	<-s.done
+	return s.Err()
+}
+
+// Done is closed when s is released or lost.
+func (s *Lease) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why s ended: nil while it is held and ErrNotHeld when it was lost.
+func (s *Lease) Err() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.err == errReleased {
+		return nil
+	}
+	return s.err
+}
+
+// Expires returns when s expires unless it is renewed.
+func (s *Lease) Expires() time.Time {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.expire
+}
+
+// Fence admits fencing tokens in increasing order.
+type Fence struct {
+	m    sync.Mutex
+	last uint64
+}
+
+// Admit reports whether token is not older than any token admitted before.
+func (f *Fence) Admit(token uint64) bool {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if token < f.last {
+		return false
+	}
+	f.last = token
+	return true
+}
@@ -0,0 +1,234 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/masu-mi/gimmick.git/chord"
+	"github.com/masu-mi/gimmick.git/chord/chordsim"
+)
+
+// ring returns a stabilized ring of nodes at addrs, which are hex ids.
+func ring(addrs ...string) []*chord.Node {
+	var nodes []*chord.Node
+	for _, a := range addrs {
+		n := chord.NewNode(a, 62, chordsim.Hash)
+		n.SetReplicationFactor(2)
+		if len(nodes) == 0 {
+			n.Create()
+		} else {
+			n.Join(nodes[0])
+		}
+		nodes = append(nodes, n)
+		maintain(nodes)
+	}
+	return nodes
+}
+
+func maintain(nodes []*chord.Node) {
+	for i := 0; i < 2*len(nodes); i++ {
+		for _, n := range nodes {
+			n.Maintain()
+		}
+	}
+}
+
+func TestMutualExclusion(t *testing.T) {
+	nodes := ring("10", "20", "30")
+	a := &Locker{Client: &chord.Client{Node: nodes[0]}, TTL: time.Minute}
+	b := &Locker{Client: &chord.Client{Node: nodes[2]}, TTL: time.Minute}
+	ctx := context.Background()
+
+	s, err := a.TryAcquire(ctx, "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.TryAcquire(ctx, "18"); err != ErrLocked {
+		t.Errorf("acquired a held lock: %v", err)
+	}
+	if _, err := a.TryAcquire(ctx, "18"); err != ErrLocked {
+		t.Errorf("acquired a held lock twice: %v", err)
+	}
+	if err := s.Release(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Error("released lease isn't done")
+	}
+	u, err := b.TryAcquire(ctx, "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Release()
+	if u.Token <= s.Token {
+		t.Errorf("token didn't increase: %d after %d", u.Token, s.Token)
+	}
+	f := &Fence{}
+	if !f.Admit(u.Token) || f.Admit(s.Token) {
+		t.Error("fence admitted a stale token")
+	}
+}
+
+func TestAcquireWaits(t *testing.T) {
+	nodes := ring("10", "20", "30")
+	a := &Locker{Client: &chord.Client{Node: nodes[0]}, TTL: time.Minute}
+	b := &Locker{Client: &chord.Client{Node: nodes[1], WatchInterval: 10 * time.Millisecond}, TTL: time.Minute}
+
+	s, err := a.Acquire(context.Background(), "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := b.Acquire(ctx, "18"); err != context.DeadlineExceeded {
+		t.Fatalf("acquired a held lock: %v", err)
+	}
+
+	got := make(chan *Lease)
+	go func() {
+		u, err := b.Acquire(context.Background(), "18")
+		if err != nil {
+			t.Error(err)
+		}
+		got <- u
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Release()
+	select {
+	case u := <-got:
+		if u != nil {
+			u.Release()
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter didn't acquire a released lock")
+	}
+}
+
+func TestReleaseOnCancel(t *testing.T) {
+	nodes := ring("10", "20", "30")
+	a := &Locker{Client: &chord.Client{Node: nodes[0]}, TTL: time.Minute}
+	b := &Locker{Client: &chord.Client{Node: nodes[1]}, TTL: time.Minute}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := a.TryAcquire(ctx, "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("lease wasn't released on cancel")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("release failed: %v", err)
+	}
+	u, err := b.TryAcquire(context.Background(), "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Release()
+}
+
+func TestLeaseExpires(t *testing.T) {
+	defer func() { now = time.Now }()
+	base := time.Now()
+	now = func() time.Time { return base }
+
+	nodes := ring("10", "20", "30")
+	a := &Locker{Client: &chord.Client{Node: nodes[0]}, TTL: time.Hour}
+	b := &Locker{Client: &chord.Client{Node: nodes[1]}, TTL: time.Hour}
+	s, err := a.TryAcquire(context.Background(), "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the holder stalled beyond its lease
+	now = func() time.Time { return base.Add(2 * time.Hour) }
+	u, err := b.TryAcquire(context.Background(), "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Release()
+	if err := s.Release(); err != ErrNotHeld {
+		t.Errorf("expired lease was released: %v", err)
+	}
+}
+
+func TestLeaseMovesWithOwner(t *testing.T) {
+	nodes := ring("10", "20", "30")
+	a := &Locker{Client: &chord.Client{Node: nodes[0]}, TTL: time.Minute}
+	b := &Locker{Client: &chord.Client{Node: nodes[2]}, TTL: time.Minute}
+	s, err := a.TryAcquire(context.Background(), "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1c takes over key 18 from 20
+	late := chord.NewNode("1c", 62, chordsim.Hash)
+	late.SetReplicationFactor(2)
+	if err := late.Join(nodes[0]); err != nil {
+		t.Fatal(err)
+	}
+	nodes = append(nodes, late)
+	maintain(nodes)
+	if o, _, _ := nodes[0].Lookup(chordsim.Hash("18")); o != late {
+		t.Fatalf("18 is owned by %s", o.Addr())
+	}
+
+	if _, err := b.TryAcquire(context.Background(), "18"); err != ErrLocked {
+		t.Errorf("acquired a held lock on the new owner: %v", err)
+	}
+	if err := s.Release(); err != nil {
+		t.Fatalf("lease was lost on the new owner: %v", err)
+	}
+	u, err := b.TryAcquire(context.Background(), "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Release()
+	if u.Token != s.Token+1 {
+		t.Errorf("token %d after %d", u.Token, s.Token)
+	}
+}
+
+// TestRenewWhileMaintained renews a lease while maintenance runs; run it with -race.
+func TestRenewWhileMaintained(t *testing.T) {
+	nodes := ring("10", "20", "30")
+	a := &Locker{Client: &chord.Client{Node: nodes[0]}, TTL: 300 * time.Millisecond}
+	s, err := a.Acquire(context.Background(), "18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				maintain(nodes)
+			}
+		}
+	}()
+	time.Sleep(time.Second)
+	close(stop)
+	<-done
+	if err := s.Release(); err != nil {
+		t.Errorf("lease was lost under maintenance: %v", err)
+	}
+}
+
+func TestAcquireReadsAllReplicas(t *testing.T) {
+	nodes := ring("10", "20", "30")
+	a := &Locker{Client: &chord.Client{Node: nodes[0]}, TTL: time.Minute}
+	// 30 keeps the replica of key 18, which may hold a newer token than 20
+	nodes[2].Fail()
+	if _, err := a.TryAcquire(context.Background(), "18"); err != chord.ErrUnavailable {
+		t.Errorf("acquired without a replica: %v", err)
+	}
+}
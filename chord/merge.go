@@ -0,0 +1,185 @@
+package chord
+
+// Merge
+//
+// A partition lets each side go on as a separate ring and nothing in
+// stabilize brings them together again. Nodes remember peers they have
+// seen and probe one of them each maintenance round. A peer j belongs to
+// another ring when neither ring routes to the other node:
+//
+//	j.locateSuccessor(n.id) != n && n.locateSuccessor(j.id) != j
+//
+// Then the node in the ring with the larger first id moves to j's ring
+// by joining it again. Old neighbours are told about j, so the ring is
+// moved over node by node and the two rings end interleaved.
+// Keys follow by rehome; the newer of conflicting values wins.
+
+// maxRememberedPeers bounds peers a node probes.
+const maxRememberedPeers = 32
+
+// Remember adds addresses to peers probed for other rings.
+// The oldest ones are forgotten beyond a bound.
+func (n *Node) Remember(addrs ...string) {
+	n.rm.Lock()
+	defer n.rm.Unlock()
+	for _, a := range addrs {
+		if a == n.addr || n.remembers(a) {
+			continue
+		}
+		if len(n.peers) >= maxRememberedPeers {
+			n.peers = n.peers[1:]
+		}
+		n.peers = append(n.peers, a)
+	}
+}
+
+// remembers reports whether addr is in peers; rm must be held.
+func (n *Node) remembers(addr string) bool {
+	for _, p := range n.peers {
+		if p == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// peerList returns a copy of peers.
+func (n *Node) peerList() []string {
+	n.rm.RLock()
+	defer n.rm.RUnlock()
+	return append([]string(nil), n.peers...)
+}
+
+// nextPeerAddr returns the peer to probe next.
+func (n *Node) nextPeerAddr() (string, bool) {
+	n.rm.Lock()
+	defer n.rm.Unlock()
+	if len(n.peers) == 0 {
+		return "", false
+	}
+	n.nextPeer = (n.nextPeer + 1) % len(n.peers)
+	return n.peers[n.nextPeer], true
+}
+
+// setMisplaced marks that n may hold keys it is not responsible for.
+func (n *Node) setMisplaced() {
+	n.rm.Lock()
+	defer n.rm.Unlock()
+	n.misplaced = true
+}
+
+// takeMisplaced clears the mark of setMisplaced and reports whether it was set.
+func (n *Node) takeMisplaced() bool {
+	n.rm.Lock()
+	defer n.rm.Unlock()
+	m := n.misplaced
+	n.misplaced = false
+	return m
+}
+
+// probe checks the next remembered peer and merges n into its ring
+// when it is another one. A node which lost every successor rejoins
+// through any reachable peer or starts over alone.
+func (n *Node) probe() {
+	if n.resolve == nil {
+		return
+	}
+	if n.liveSuccessor() == nil {
+		n.recover()
+		return
+	}
+	addr, ok := n.nextPeerAddr()
+	if !ok {
+		return
+	}
+	j, err := n.resolve(addr)
+	if err != nil || j == nil || j == n || n.unreachable(j) {
+		return
+	}
+	if j.locateSuccessor(n.id) == n || n.locateSuccessor(j.id) == j {
+		return
+	}
+	mine, theirs := n.locateSuccessor(0), j.locateSuccessor(0)
+	if mine == nil || theirs == nil {
+		return
+	}
+	if mine.id < theirs.id {
+		// n's ring survives; let j find n
+		j.Remember(n.addr)
+		return
+	}
+	n.merge(j)
+}
+
+// recover rejoins n to a ring after all of its successors became unreachable.
+func (n *Node) recover() {
+	for _, a := range n.peerList() {
+		j, err := n.resolve(a)
+		if err != nil || j == nil || j == n || n.unreachable(j) || j.liveSuccessor() == nil {
+			continue
+		}
+		if n.merge(j) == nil {
+			return
+		}
+	}
+	n.createNewRing()
+}
+
+// merge moves n into j's ring. n keeps its keys until rehome places them.
+func (n *Node) merge(j *Node) error {
+	old := append([]*Node{n.pred()}, n.succs()...)
+	// fingers point into the old ring; readers may hold copies of the old ones
+	n.rm.Lock()
+	n.finger, n.candidates = nil, nil
+	n.rm.Unlock()
+	if err := n.joinRing(j); err != nil {
+		return err
+	}
+	n.setMisplaced()
+	for _, o := range old {
+		if o != nil && o != n && !n.unreachable(o) {
+			o.Remember(j.addr)
+		}
+	}
+	n.emit(Event{Type: RingMerged, To: j.addr})
+	return nil
+}
+
+// rehome stores keys n neither owns nor replicates on their owners.
+// The newer of conflicting values wins.
+func (n *Node) rehome() {
+	p := n.pred()
+	if p == nil || n.unreachable(p) || !n.takeMisplaced() {
+		return
+	}
+	moved, kept := map[*Node]map[string]entry{}, false
+	n.m.Lock()
+	for k, v := range n.data {
+		id := n.Hash(k)
+		if n.holds(id) {
+			continue
+		}
+		s := n.locateSuccessor(id)
+		if s == nil || s == n || n.unreachable(s) {
+			kept = true
+			continue
+		}
+		if moved[s] == nil {
+			moved[s] = map[string]entry{}
+		}
+		moved[s][k] = v
+		delete(n.data, k)
+		n.version++
+	}
+	n.m.Unlock()
+	for s, data := range moved {
+		for k, v := range data {
+			s.storeEntry(k, v)
+		}
+		s.setMisplaced()
+		n.keysTransferred(s, len(data))
+	}
+	if kept {
+		n.setMisplaced()
+	}
+}
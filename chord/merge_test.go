@@ -0,0 +1,115 @@
+package chord
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMergeRings(t *testing.T) {
+	hash := generateTestHash(16)
+	a, b := generateNodes(4, 0, 4), generateNodes(4, 2, 4)
+	for _, n := range b {
+		n.hash = hash
+		n.id = hash(n.addr)
+	}
+	setupRingStatically(a, 2)
+	setupRingStatically(b, 2)
+	r := &Registry{}
+	all := append(append([]*Node{}, a...), b...)
+	for _, n := range all {
+		n.SetSuccessorListLength(2)
+		n.resolve = r.Resolve
+		r.Register(n)
+	}
+	for i := 0; i < 16; i++ {
+		key := fmt.Sprintf("%x", i)
+		ring := a
+		if i%2 == 1 {
+			ring = b
+		}
+		if err := ring[0].Put(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// one node of b remembers a peer of a
+	b[2].Remember(a[1].addr)
+
+	events, cancel := b[2].Subscribe(16)
+	defer cancel()
+	for round := 0; round < 40 && checkRingConverged(all) != nil; round++ {
+		for _, n := range all {
+			n.Maintain()
+		}
+	}
+	if err := checkRingConverged(all); err != nil {
+		t.Fatalf("rings were not merged: %s", err)
+	}
+	merged := false
+	for len(events) > 0 {
+		if e := <-events; e.Type == RingMerged {
+			merged = true
+		}
+	}
+	if !merged {
+		t.Errorf("b[2] didn't report merge")
+	}
+	for i := 0; i < 16; i++ {
+		key := fmt.Sprintf("%x", i)
+		v, err := a[3].Get(key)
+		if err != nil {
+			t.Errorf("%s: %s", key, err)
+			continue
+		}
+		if b, _ := ioutil.ReadAll(v); string(b) != key {
+			t.Errorf("%s: unexpected value %q", key, b)
+		}
+	}
+}
+
+// TestMergeWhileRemembering merges rings while peers are learned; run it with -race.
+func TestMergeWhileRemembering(t *testing.T) {
+	hash := generateTestHash(16)
+	a, b := generateNodes(4, 0, 4), generateNodes(4, 2, 4)
+	for _, n := range b {
+		n.hash = hash
+		n.id = hash(n.addr)
+	}
+	setupRingStatically(a, 2)
+	setupRingStatically(b, 2)
+	r := &Registry{}
+	all := append(append([]*Node{}, a...), b...)
+	for _, n := range all {
+		n.SetSuccessorListLength(2)
+		n.resolve = r.Resolve
+		r.Register(n)
+	}
+	b[2].Put("5", strings.NewReader("5"))
+	b[2].Remember(a[1].addr)
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, n := range all {
+				n.Remember(a[i%len(a)].addr, b[i%len(b)].addr)
+			}
+		}
+	}()
+	for round := 0; round < 100 && checkRingConverged(all) != nil; round++ {
+		for _, n := range all {
+			n.Maintain()
+		}
+	}
+	close(stop)
+	<-done
+	if err := checkRingConverged(all); err != nil {
+		t.Errorf("rings were not merged: %s", err)
+	}
+}
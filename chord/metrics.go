@@ -0,0 +1,160 @@
+package chord
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Metrics receives measurements of a node.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	Lookup(hops int, err error)
+	Stabilized()
+	FingerChanged()
+	SuccessorChanged()
+	PredecessorChanged()
+	FailureDetected()
+	KeysTransferred(n int)
+	// AntiEntropy reports a round of anti-entropy:
+	// leaves which differed from the replica and keys copied to repair them.
+	AntiEntropy(divergent, repaired int)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Lookup(int, error)    {}
+func (nopMetrics) Stabilized()          {}
+func (nopMetrics) FingerChanged()       {}
+func (nopMetrics) SuccessorChanged()    {}
+func (nopMetrics) PredecessorChanged()  {}
+func (nopMetrics) FailureDetected()     {}
+func (nopMetrics) KeysTransferred(int)  {}
+func (nopMetrics) AntiEntropy(int, int) {}
+
+// SetMetrics sets where n reports its measurements.
+func (n *Node) SetMetrics(m Metrics) {
+	n.metrics = m
+}
+
+func (n *Node) metric() Metrics {
+	if n.metrics == nil {
+		return nopMetrics{}
+	}
+	return n.metrics
+}
+
+// hopBuckets are upper bounds of chord_lookup_hops histogram.
+var hopBuckets = []int{1, 2, 4, 8, 16, 32, 64}
+
+// Counters is Metrics which counts in memory and exports Prometheus text format.
+type Counters struct {
+	// Node is the value of "node" label.
+	Node string
+
+	lookups, lookupFailures int64
+	hops                    [8]int64 // hopBuckets and +Inf, not cumulative
+	hopSum                  int64
+	stabilizations          int64
+	fingerChanges           int64
+	successorChanges        int64
+	predecessorChanges      int64
+	failuresDetected        int64
+	keysTransferred         int64
+	antiEntropyRounds       int64
+	divergentLeaves         int64
+	keysRepaired            int64
+}
+
+var _ Metrics = (*Counters)(nil)
+
+// NewCounters creates Counters labeled by n's address.
+func NewCounters(n *Node) *Counters {
+	return &Counters{Node: n.addr}
+}
+
+func (c *Counters) Lookup(hops int, err error) {
+	atomic.AddInt64(&c.lookups, 1)
+	if err != nil {
+		atomic.AddInt64(&c.lookupFailures, 1)
+		return
+	}
+	i := 0
+	for i < len(hopBuckets) && hops > hopBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&c.hops[i], 1)
+	atomic.AddInt64(&c.hopSum, int64(hops))
+}
+func (c *Counters) Stabilized()         { atomic.AddInt64(&c.stabilizations, 1) }
+func (c *Counters) FingerChanged()      { atomic.AddInt64(&c.fingerChanges, 1) }
+func (c *Counters) SuccessorChanged()   { atomic.AddInt64(&c.successorChanges, 1) }
+func (c *Counters) PredecessorChanged() { atomic.AddInt64(&c.predecessorChanges, 1) }
+func (c *Counters) FailureDetected()    { atomic.AddInt64(&c.failuresDetected, 1) }
+func (c *Counters) KeysTransferred(n int) {
+	atomic.AddInt64(&c.keysTransferred, int64(n))
+}
+func (c *Counters) AntiEntropy(divergent, repaired int) {
+	atomic.AddInt64(&c.antiEntropyRounds, 1)
+	atomic.AddInt64(&c.divergentLeaves, int64(divergent))
+	atomic.AddInt64(&c.keysRepaired, int64(repaired))
+}
+
+// ServeHTTP exports c in Prometheus text format.
+func (c *Counters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WritePrometheus(w, c)
+}
+
+// WritePrometheus writes cs in Prometheus text format, one series per node.
+func WritePrometheus(w io.Writer, cs ...*Counters) error {
+	counters := []struct {
+		name, help string
+		value      func(*Counters) *int64
+	}{
+		{"chord_lookups_total", "Lookups started by clients.", func(c *Counters) *int64 { return &c.lookups }},
+		{"chord_lookup_failures_total", "Lookups which did not reach an alive node.", func(c *Counters) *int64 { return &c.lookupFailures }},
+		{"chord_stabilize_rounds_total", "Rounds of stabilize.", func(c *Counters) *int64 { return &c.stabilizations }},
+		{"chord_finger_changes_total", "Finger table entries replaced.", func(c *Counters) *int64 { return &c.fingerChanges }},
+		{"chord_successor_changes_total", "Successor replaced.", func(c *Counters) *int64 { return &c.successorChanges }},
+		{"chord_predecessor_changes_total", "Predecessor replaced.", func(c *Counters) *int64 { return &c.predecessorChanges }},
+		{"chord_failures_detected_total", "Peers found failed.", func(c *Counters) *int64 { return &c.failuresDetected }},
+		{"chord_keys_transferred_total", "Keys handed to other nodes.", func(c *Counters) *int64 { return &c.keysTransferred }},
+		{"chord_antientropy_rounds_total", "Rounds of anti-entropy with a replica.", func(c *Counters) *int64 { return &c.antiEntropyRounds }},
+		{"chord_antientropy_divergent_leaves_total", "Merkle leaves which differed from the replica.", func(c *Counters) *int64 { return &c.divergentLeaves }},
+		{"chord_antientropy_repaired_keys_total", "Keys copied to repair replicas.", func(c *Counters) *int64 { return &c.keysRepaired }},
+	}
+	for _, m := range counters {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name); err != nil {
+			return err
+		}
+		for _, c := range cs {
+			if _, err := fmt.Fprintf(w, "%s{node=%q} %d\n", m.name, c.Node, atomic.LoadInt64(m.value(c))); err != nil {
+				return err
+			}
+		}
+	}
+	const hops = "chord_lookup_hops"
+	if _, err := fmt.Fprintf(w, "# HELP %s Hops of successful lookups.\n# TYPE %s histogram\n", hops, hops); err != nil {
+		return err
+	}
+	for _, c := range cs {
+		var cum int64
+		for i := range c.hops {
+			cum += atomic.LoadInt64(&c.hops[i])
+			le := "+Inf"
+			if i < len(hopBuckets) {
+				le = fmt.Sprint(hopBuckets[i])
+			}
+			if _, err := fmt.Fprintf(w, "%s_bucket{node=%q,le=%q} %d\n", hops, c.Node, le, cum); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum{node=%q} %d\n%s_count{node=%q} %d\n",
+			hops, c.Node, atomic.LoadInt64(&c.hopSum), hops, c.Node, cum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
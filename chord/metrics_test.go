@@ -0,0 +1,73 @@
+package chord
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricsAndEvents(t *testing.T) {
+	nodes := generateNodes(4, 0, 4)
+	counters := make([]*Counters, len(nodes))
+	for i, n := range nodes {
+		counters[i] = NewCounters(n)
+		n.SetMetrics(counters[i])
+	}
+	base := nodes[0]
+	events, cancel := base.Subscribe(64)
+	base.createNewRing()
+	base.storeLocal("6", []byte("v"))
+	for i, n := range nodes[1:] {
+		n.joinRing(base)
+		for _, m := range nodes[:i+2] {
+			m.stabilize()
+		}
+	}
+	base.Lookup(6)
+	nodes[3].Fail()
+	base.checkPredecessor()
+	cancel()
+
+	var got []string
+	for e := range events {
+		got = append(got, e.String())
+	}
+	for _, expected := range []string{
+		"0: successor changed from none to 0",
+		"0: predecessor changed from none to 4",
+		"0: 1 keys transferred to 8",
+		"0: failure of c detected",
+		"0: predecessor changed from c to none",
+	} {
+		if !contains(got, expected) {
+			t.Errorf("event %q was not delivered: %q", expected, got)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := WritePrometheus(buf, counters...); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, expected := range []string{
+		"# TYPE chord_lookups_total counter\n",
+		`chord_lookups_total{node="0"} 1` + "\n",
+		`chord_keys_transferred_total{node="0"} 1` + "\n",
+		`chord_failures_detected_total{node="0"} 1` + "\n",
+		`chord_lookup_hops_bucket{node="0",le="+Inf"} 1` + "\n",
+		`chord_lookup_hops_count{node="4"} 0` + "\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("%q is not exported:\n%s", expected, out)
+		}
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
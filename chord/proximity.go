@@ -0,0 +1,117 @@
+package chord
+
+import (
+	"fmt"
+
+	"github.com/masu-mi/gimmick.git/sets/s1"
+)
+
+// defaultFingerCandidates is how many nodes a finger slot remembers.
+const defaultFingerCandidates = 3
+
+// SetTransport sets the transport which measures distance to peers.
+// Without transport fingers are chosen by ID only.
+func (n *Node) SetTransport(t Transport) {
+	n.transport = t
+}
+
+// fingerStart returns the first id of i-th finger interval.
+func (n *Node) fingerStart(i uint32) uint64 {
+	return n.Hash(fmt.Sprintf("%x", n.id+2<<i))
+}
+
+// fingerEnd returns the id just after i-th finger interval.
+func (n *Node) fingerEnd(i uint32) uint64 {
+	if i >= n.lastIndex {
+		return n.id
+	}
+	return n.fingerStart(i + 1)
+}
+
+// fingerCandidates collects first and the nodes following it
+// while they stay in [first, end).
+func (n *Node) fingerCandidates(first *Node, end uint64) []*Node {
+	cands := []*Node{first}
+	for c := first; len(cands) < n.candidateSize; {
+		if c = c.Successor(); c == nil {
+			break
+		}
+		if c == n || c == first || n.unreachable(c) {
+			break
+		}
+		if s1.Equal(c.id, end) || s1.RotationNumber(first.id, c.id, end) != 1 {
+			break
+		}
+		cands = append(cands, c)
+	}
+	return cands
+}
+
+// nearest returns the candidate with the lowest measured RTT.
+// Unmeasured candidates lose against measured ones and ties keep ID order.
+func (n *Node) nearest(cands []*Node) *Node {
+	if len(cands) == 0 {
+		return nil
+	}
+	best := cands[0]
+	if n.transport == nil {
+		return best
+	}
+	bestRTT, measured := n.transport.RTT(n.addr, best.addr)
+	for _, c := range cands[1:] {
+		rtt, ok := n.transport.RTT(n.addr, c.addr)
+		if !ok {
+			continue
+		}
+		if !measured || rtt < bestRTT {
+			best, bestRTT, measured = c, rtt, true
+		}
+	}
+	return best
+}
+
+// setFinger stores cands as i-th slot and picks the nearest one as finger.
+// While the table is short the slot is appended instead.
+func (n *Node) setFinger(i int, cands []*Node) {
+	if p, ok := n.transport.(Prober); ok {
+		for _, c := range cands {
+			if c != nil && c != n {
+				p.Probe(n.addr, c.addr)
+			}
+		}
+	}
+	f := n.nearest(cands)
+	n.rm.Lock()
+	if len(n.finger) < int(n.lastIndex)+1 {
+		i = len(n.finger)
+		n.finger = append(n.finger, nil)
+	}
+	for len(n.candidates) < len(n.finger) {
+		n.candidates = append(n.candidates, nil)
+	}
+	old := n.finger[i]
+	n.finger[i] = f
+	n.candidates[i] = cands
+	n.rm.Unlock()
+	if old != f {
+		n.metric().FingerChanged()
+		n.emit(Event{Type: FingerChanged, Finger: i, From: addrOf(old), To: addrOf(f)})
+	}
+}
+
+// precedingCandidate returns the nearest alive one of finger and its cands in (n, k].
+func (n *Node) precedingCandidate(finger *Node, cands []*Node, k uint64) *Node {
+	if len(cands) == 0 {
+		cands = []*Node{finger}
+	}
+	var preceding []*Node
+	for _, c := range cands {
+		if c == nil || n.unreachable(c) || s1.Equal(n.id, c.id) {
+			continue
+		}
+		if s1.RotationNumber(n.id, c.id, k) == 1 {
+			preceding = append(preceding, c)
+		}
+	}
+	return n.nearest(preceding)
+}
@@ -0,0 +1,95 @@
+package chord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/masu-mi/gimmick.git/sets/s1"
+)
+
+type staticRTT map[string]time.Duration
+
+func (t staticRTT) RTT(from, to string) (time.Duration, bool) {
+	d, ok := t[to]
+	return d, ok
+}
+
+func TestProximityNeighborSelection(t *testing.T) {
+	type test struct {
+		title     string
+		transport Transport
+		expected  uint64 // finger of interval [32, 0)
+		rejected  uint64
+	}
+	for _, set := range []test{
+		test{
+			title:     "without transport fingers are chosen by ID",
+			transport: nil,
+			expected:  32, rejected: 40,
+		},
+		test{
+			title:     "unmeasured candidates are not preferred",
+			transport: staticRTT{"2c": 1 * time.Millisecond},
+			expected:  32, rejected: 40,
+		},
+		test{
+			title: "the nearest candidate is preferred",
+			transport: staticRTT{
+				"20": 50 * time.Millisecond, "24": 50 * time.Millisecond,
+				"28": 1 * time.Millisecond,
+			},
+			expected: 40, rejected: 32,
+		},
+		test{
+			title: "RTT is measured by pinging candidates",
+			transport: &Measured{Ping: func(from, to string) (time.Duration, error) {
+				if to == "28" {
+					return 1 * time.Millisecond, nil
+				}
+				return 50 * time.Millisecond, nil
+			}},
+			expected: 40, rejected: 32,
+		},
+	} {
+		ring := generateNodes(16, 0, 4)
+		setupRingStatically(ring, 1)
+		base := ring[0]
+		base.SetTransport(set.transport)
+		t.Run(set.title, func(t *testing.T) {
+			for i := 0; i < 2*int(base.lastIndex+1); i++ {
+				base.fixFigures()
+			}
+			var found bool
+			for _, f := range base.finger {
+				if s1.Equal(f.id, set.rejected) {
+					t.Errorf("finger(id:%d) should be replaced", f.id)
+				}
+				found = found || s1.Equal(f.id, set.expected)
+			}
+			if !found {
+				t.Errorf("finger(id:%d) is not chosen", set.expected)
+			}
+			for _, n := range ring {
+				k := (n.id + 63) % 64
+				if act := base.locateSuccessor(k); act != n {
+					t.Errorf("id:%d was located to %+v", k, act)
+				}
+			}
+		})
+	}
+}
+
+func TestRTTEstimator(t *testing.T) {
+	e := &RTTEstimator{Alpha: 0.5}
+	if _, ok := e.RTT("a", "b"); ok {
+		t.Errorf("unmeasured pair returned estimate")
+	}
+	e.Observe("a", "b", 10*time.Millisecond)
+	e.Observe("a", "b", 20*time.Millisecond)
+	if d, _ := e.RTT("a", "b"); d != 15*time.Millisecond {
+		t.Errorf("estimate %s is not smoothed", d)
+	}
+	if _, ok := e.RTT("b", "a"); ok {
+		t.Errorf("estimate is not directed")
+	}
+}
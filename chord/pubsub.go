@@ -0,0 +1,285 @@
+package chord
+
+import "github.com/masu-mi/gimmick.git/sets/s1"
+
+// Topics
+//
+// Topics follow Scribe (Castro et al., 2002). The successor of a topic's
+// id is its rendezvous node. A subscriber routes a join toward the id and
+// every node on the route becomes a forwarder holding the previous hop as
+// a child, until the join reaches a node already in the tree. A message is
+// routed to the rendezvous node and flows down the tree, so the tree is
+// built from the same finger hops as lookups and no node sends to more
+// than its children. Maintain repairs the tree after failures and joins.
+
+// Message is a message published to a topic.
+type Message struct {
+	Topic string
+	Data  []byte
+	// Node is the address of the node which published it.
+	Node string
+}
+
+// topic is n's part of the tree of a topic.
+type topic struct {
+	// parent is nil on the rendezvous node and on nodes which lost theirs.
+	parent      *Node
+	children    map[*Node]struct{}
+	subscribers map[chan Message]struct{}
+}
+
+// SubscribeTopic joins the tree of topic name and returns a channel of its
+// messages and a function to leave it. Like Subscribe, messages are
+// dropped while the channel's buffer of size buf is full. Messages
+// published while the tree is repaired may be missed.
+func (n *Node) SubscribeTopic(name string, buf int) (<-chan Message, func(), error) {
+	if n.fail() {
+		return nil, nil, ErrNodeFailed
+	}
+	ch := make(chan Message, buf)
+	n.tm.Lock()
+	t := n.topicOf(name)
+	t.subscribers[ch] = struct{}{}
+	n.tm.Unlock()
+	if err := n.graft(name, n); err != nil {
+		n.unsubscribeTopic(name, ch)
+		return nil, nil, err
+	}
+	return ch, func() { n.unsubscribeTopic(name, ch) }, nil
+}
+
+// Publish sends data to the subscribers of topic name through its rendezvous node.
+func (n *Node) Publish(name string, data []byte) error {
+	r, _, err := n.Lookup(n.Hash(name))
+	if err != nil {
+		return err
+	}
+	if n.unreachable(r) {
+		return ErrNodeFailed
+	}
+	r.disseminate(Message{Topic: name, Data: data, Node: n.addr}, map[*Node]bool{})
+	return nil
+}
+
+// topicOf returns n's state of name, creating it. n.tm must be held.
+func (n *Node) topicOf(name string) *topic {
+	if n.topics == nil {
+		n.topics = map[string]*topic{}
+	}
+	t, ok := n.topics[name]
+	if !ok {
+		t = &topic{children: map[*Node]struct{}{}, subscribers: map[chan Message]struct{}{}}
+		n.topics[name] = t
+	}
+	return t
+}
+
+func (n *Node) unsubscribeTopic(name string, ch chan Message) {
+	n.tm.Lock()
+	t, ok := n.topics[name]
+	if !ok {
+		n.tm.Unlock()
+		return
+	}
+	if _, ok := t.subscribers[ch]; ok {
+		delete(t.subscribers, ch)
+		close(ch)
+	}
+	n.tm.Unlock()
+	n.leaveIfIdle(name)
+}
+
+// leaveIfIdle drops n's state of name when n has neither subscribers nor children.
+func (n *Node) leaveIfIdle(name string) {
+	n.tm.Lock()
+	t, ok := n.topics[name]
+	if !ok || len(t.subscribers) > 0 || len(t.children) > 0 {
+		n.tm.Unlock()
+		return
+	}
+	delete(n.topics, name)
+	p := t.parent
+	n.tm.Unlock()
+	if p != nil && !n.unreachable(p) {
+		p.prune(name, n)
+	}
+}
+
+// prune removes child c from n's tree of name.
+func (n *Node) prune(name string, c *Node) {
+	n.tm.Lock()
+	if t, ok := n.topics[name]; ok {
+		delete(t.children, c)
+	}
+	n.tm.Unlock()
+	n.leaveIfIdle(name)
+}
+
+// owns reports whether n is the successor of id.
+func (n *Node) owns(id uint64) bool {
+	p := n.pred()
+	if p == nil || p == n {
+		return n.Successor() == n
+	}
+	return s1.Equal(id, n.id) || between(p.id, id, n.id)
+}
+
+// nextHop returns the node a lookup of k goes from n to.
+func (n *Node) nextHop(k uint64) *Node {
+	succ := n.liveSuccessor()
+	if succ == nil {
+		return nil
+	}
+	if s1.RotationNumber(n.id, k, succ.id) == 1 {
+		return succ
+	}
+	return n.closestPrecedingNode(k)
+}
+
+// parentOf returns n's parent in the tree of name.
+func (n *Node) parentOf(name string) *Node {
+	n.tm.Lock()
+	defer n.tm.Unlock()
+	if t, ok := n.topics[name]; ok {
+		return t.parent
+	}
+	return nil
+}
+
+// setParent replaces n's parent in the tree of name and leaves the old one.
+func (n *Node) setParent(name string, p *Node) {
+	n.tm.Lock()
+	t, ok := n.topics[name]
+	if !ok {
+		n.tm.Unlock()
+		return
+	}
+	old := t.parent
+	t.parent = p
+	n.tm.Unlock()
+	if old != nil && old != p && !n.unreachable(old) {
+		old.prune(name, n)
+	}
+}
+
+// descends reports whether n is in the subtree of c in the tree of name.
+func (n *Node) descends(name string, c *Node) bool {
+	x := n
+	for hops := 0; x != nil && hops <= maxHops; hops++ {
+		if x == c {
+			return true
+		}
+		x = x.parentOf(name)
+	}
+	return false
+}
+
+// graft attaches c to the tree of name on the route from n to the rendezvous node.
+// Nodes on the route which are not in the tree yet become forwarders.
+func (n *Node) graft(name string, c *Node) error {
+	id := n.Hash(name)
+	x := n
+	for hops := 0; ; hops++ {
+		if x == nil || hops > maxHops {
+			return ErrEmptyNode
+		}
+		if x.fail() {
+			return ErrNodeFailed
+		}
+		root := x.owns(id)
+		if x != c && !root && x.descends(name, c) {
+			// c's subtree is routed through c; attaching c to it would make a cycle
+			x = x.nextHop(id)
+			continue
+		}
+		x.tm.Lock()
+		_, joined := x.topics[name]
+		t := x.topicOf(name)
+		if x != c {
+			t.children[c] = struct{}{}
+		}
+		x.tm.Unlock()
+		if x != c {
+			c.setParent(name, x)
+		}
+		if root {
+			x.setParent(name, nil)
+			return nil
+		}
+		if joined && x != c {
+			return nil
+		}
+		if joined && x.parentOf(name) != nil {
+			// c is already in the tree
+			return nil
+		}
+		c, x = x, x.nextHop(id)
+	}
+}
+
+// disseminate delivers m to n's subscribers and sends it to n's children.
+// seen guards against cycles left while the tree is repaired.
+func (n *Node) disseminate(m Message, seen map[*Node]bool) {
+	seen[n] = true
+	n.tm.Lock()
+	t, ok := n.topics[m.Topic]
+	if !ok {
+		n.tm.Unlock()
+		return
+	}
+	for ch := range t.subscribers {
+		select {
+		case ch <- m:
+		default:
+		}
+	}
+	var children []*Node
+	for c := range t.children {
+		children = append(children, c)
+	}
+	n.tm.Unlock()
+	for _, c := range children {
+		if !seen[c] && !n.unreachable(c) {
+			c.disseminate(m, seen)
+		}
+	}
+}
+
+// repairTopics drops unreachable children and joins the tree again
+// where n lost its parent or stopped being the rendezvous node.
+func (n *Node) repairTopics() {
+	n.tm.Lock()
+	var names []string
+	for name, t := range n.topics {
+		for c := range t.children {
+			if n.unreachable(c) {
+				delete(t.children, c)
+			}
+		}
+		names = append(names, name)
+	}
+	n.tm.Unlock()
+	for _, name := range names {
+		n.leaveIfIdle(name)
+		n.tm.Lock()
+		t, ok := n.topics[name]
+		var p *Node
+		if ok {
+			p = t.parent
+		}
+		n.tm.Unlock()
+		if !ok {
+			continue
+		}
+		root := n.owns(n.Hash(name))
+		switch {
+		case root && p != nil:
+			n.setParent(name, nil)
+		case !root && (p == nil || n.unreachable(p)):
+			n.setParent(name, nil)
+			if next := n.nextHop(n.Hash(name)); next != nil && next != n {
+				next.graft(name, n)
+			}
+		}
+	}
+}
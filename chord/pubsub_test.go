@@ -0,0 +1,139 @@
+package chord
+
+import (
+	"fmt"
+	"testing"
+)
+
+// topicRing returns 16 nodes of 0, 10, ... f0 with full finger tables.
+func topicRing() []*Node {
+	hash := generateTestHash(256)
+	var ring []*Node
+	for i := 0; i < 16; i++ {
+		ring = append(ring, NewNode(fmt.Sprintf("%x", i*16), 6, hash))
+	}
+	setupRingStatically(ring, 3)
+	for round := 0; round < 8; round++ {
+		for _, n := range ring {
+			n.Maintain()
+		}
+	}
+	return ring
+}
+
+func received(ch <-chan Message) []string {
+	var got []string
+	for len(ch) > 0 {
+		got = append(got, string((<-ch).Data))
+	}
+	return got
+}
+
+func topicState(n *Node, name string) (t topic, ok bool) {
+	n.tm.Lock()
+	defer n.tm.Unlock()
+	if p, ok := n.topics[name]; ok {
+		return *p, true
+	}
+	return topic{}, false
+}
+
+func TestTopicTree(t *testing.T) {
+	ring := topicRing()
+	// topic 85 meets on 90
+	root := ring[9]
+	subs := map[int]<-chan Message{}
+	cancels := map[int]func(){}
+	for _, i := range []int{0, 3, 9, 12} {
+		ch, cancel, err := ring[i].SubscribeTopic("85", 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs[i], cancels[i] = ch, cancel
+	}
+	for _, n := range ring {
+		if _, ok := topicState(n, "85"); !ok {
+			continue
+		}
+		x, hops := n, 0
+		for ; x.parentOf("85") != nil && hops < len(ring); hops++ {
+			x = x.parentOf("85")
+		}
+		if x != root {
+			t.Errorf("id:%x is in a tree rooted at id:%x", n.id, x.id)
+		}
+	}
+	// joins from 0 and 30 meet on 80, which forwards without subscribing
+	if f, ok := topicState(ring[8], "85"); !ok || len(f.subscribers) != 0 || len(f.children) < 2 {
+		t.Errorf("80 isn't a forwarder: %+v", f)
+	}
+
+	if err := ring[5].Publish("85", []byte("m1")); err != nil {
+		t.Fatal(err)
+	}
+	for i, ch := range subs {
+		if got := received(ch); len(got) != 1 || got[0] != "m1" {
+			t.Errorf("id:%x received %q", ring[i].id, got)
+		}
+	}
+
+	for _, i := range []int{0, 3, 12} {
+		cancels[i]()
+	}
+	ring[5].Publish("85", []byte("m2"))
+	if got := received(subs[9]); len(got) != 1 {
+		t.Errorf("root received %q", got)
+	}
+	for _, n := range ring {
+		if _, ok := topicState(n, "85"); ok && n != root {
+			t.Errorf("id:%x stays in the tree without subscribers", n.id)
+		}
+	}
+}
+
+func TestTopicRepair(t *testing.T) {
+	ring := topicRing()
+	var subs []<-chan Message
+	for _, i := range []int{0, 3} {
+		ch, _, err := ring[i].SubscribeTopic("85", 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, ch)
+	}
+	publish := func(data string) {
+		t.Helper()
+		if err := ring[5].Publish("85", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		for i, ch := range subs {
+			if got := received(ch); len(got) != 1 || got[0] != data {
+				t.Errorf("subscriber %d received %q", i, got)
+			}
+		}
+	}
+	maintain := func(nodes []*Node) {
+		for round := 0; round < 8; round++ {
+			for _, n := range nodes {
+				n.Maintain()
+			}
+		}
+	}
+
+	// the forwarder fails
+	ring[8].Fail()
+	maintain(ring)
+	publish("after failure")
+
+	// 88 takes over topic 85 from 90
+	late := NewNode("88", 6, generateTestHash(256))
+	if err := late.Join(ring[0]); err != nil {
+		t.Fatal(err)
+	}
+	all := append(ring, late)
+	maintain(all)
+	if f, ok := topicState(late, "85"); !ok || f.parent != nil {
+		t.Errorf("88 isn't the root: %+v", f)
+	}
+	publish("after join")
+}
@@ -0,0 +1,262 @@
+package chord
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	"github.com/masu-mi/gimmick.git/sets/s1"
+)
+
+var (
+	ErrNotFound   = errors.New("key not found")
+	ErrInvalidTTL = errors.New("ttl is negative")
+)
+
+var _ StorageService = (*Node)(nil)
+
+// Put stores value on the node responsible for key and its replicas.
+func (n *Node) Put(key string, value io.Reader) error {
+	return n.PutTTL(key, value, 0)
+}
+
+// PutTTL stores value which expires after ttl. Zero ttl means it never expires.
+// Negative ttl, e.g. of a passed deadline, is ErrInvalidTTL.
+func (n *Node) PutTTL(key string, value io.Reader, ttl time.Duration) error {
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+	b, err := ioutil.ReadAll(value)
+	if err != nil {
+		return err
+	}
+	s, _, err := n.Lookup(n.Hash(key))
+	if err != nil {
+		return err
+	}
+	e := newEntry(b, ttl)
+	s.storeEntry(key, e)
+	for _, r := range s.replicaSet() {
+		r.storeEntry(key, e)
+	}
+	return nil
+}
+
+// Get returns value stored on the node responsible for key.
+func (n *Node) Get(key string) (io.ReadCloser, error) {
+	s, _, err := n.Lookup(n.Hash(key))
+	if err != nil {
+		return nil, err
+	}
+	b, ok := s.loadLocal(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// entry is a stored value. The newest stamp wins among replicas.
+// An expired entry stays as a tombstone for tombstoneGrace, so that a stale
+// replica doesn't bring the key back, and then it is dropped.
+type entry struct {
+	value []byte
+	stamp int64
+	// expire is the time in unix nano the entry expires at; zero means never.
+	expire int64
+	tomb   bool
+	// deleted marks a tombstone written by Delete rather than by expiry.
+	deleted bool
+}
+
+// tombstoneGrace is how long expired keys are kept as tombstones.
+// Replicas unreachable longer than it may bring keys back.
+const tombstoneGrace = 10 * time.Minute
+
+func newEntry(value []byte, ttl time.Duration) entry {
+	e := entry{value: value, stamp: stamp()}
+	if ttl > 0 {
+		e.expire = now().Add(ttl).UnixNano()
+	}
+	return e
+}
+
+// newTombstone returns the entry deleting a key now.
+// It is collected like an expired key.
+func newTombstone() entry {
+	s := stamp()
+	return entry{stamp: s, expire: s, tomb: true, deleted: true}
+}
+
+// live reports whether e is visible at t.
+func (e entry) live(t time.Time) bool {
+	return !e.tomb && (e.expire == 0 || t.UnixNano() < e.expire)
+}
+
+func (e entry) newer(f entry) bool {
+	if e.stamp != f.stamp {
+		return e.stamp > f.stamp
+	}
+	if e.tomb != f.tomb {
+		return e.tomb
+	}
+	return string(e.value) > string(f.value)
+}
+
+// now is the clock stamping writes.
+var now = time.Now
+
+var lastStamp int64
+
+// stamp returns a write stamp after every stamp taken before in this process.
+func stamp() int64 {
+	for {
+		last, t := atomic.LoadInt64(&lastStamp), now().UnixNano()
+		if t <= last {
+			t = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastStamp, last, t) {
+			return t
+		}
+	}
+}
+
+// storeLocal writes value as a new write.
+func (n *Node) storeLocal(key string, value []byte) {
+	n.storeEntry(key, entry{value: value, stamp: stamp()})
+}
+
+// storeEntry stores e unless n has a newer one. It reports whether e was stored.
+func (n *Node) storeEntry(key string, e entry) bool {
+	n.m.Lock()
+	if old, ok := n.data[key]; ok && !e.newer(old) {
+		n.m.Unlock()
+		return false
+	}
+	if n.data == nil {
+		n.data = map[string]entry{}
+	}
+	n.data[key] = e
+	n.version++
+	n.m.Unlock()
+	n.keyChanged(key, e)
+	return true
+}
+
+func (n *Node) loadLocal(key string) ([]byte, bool) {
+	e, ok := n.loadEntry(key)
+	if !ok || !e.live(now()) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (n *Node) loadEntry(key string) (entry, bool) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	e, ok := n.data[key]
+	return e, ok
+}
+
+// collectExpired turns expired keys into tombstones and drops them after tombstoneGrace.
+func (n *Node) collectExpired() {
+	t := now().UnixNano()
+	expired := map[string]entry{}
+	n.m.Lock()
+	for k, e := range n.data {
+		switch {
+		case e.expire == 0 || t < e.expire:
+		case t >= e.expire+int64(tombstoneGrace):
+			delete(n.data, k)
+			n.version++
+		case !e.tomb:
+			n.data[k] = entry{stamp: e.stamp, expire: e.expire, tomb: true}
+			n.version++
+			expired[k] = n.data[k]
+		}
+	}
+	n.m.Unlock()
+	for k, e := range expired {
+		n.keyChanged(k, e)
+	}
+}
+
+// transferKeys moves keys which n is not responsible for, (n.predecessor, n], to j.
+// With replication n keeps them as a replica of j.
+func (n *Node) transferKeys(j *Node) int {
+	if j == nil || j == n || n.unreachable(j) {
+		return 0
+	}
+	moved := map[string]entry{}
+	n.m.Lock()
+	for k, v := range n.data {
+		id := n.Hash(k)
+		if s1.Equal(id, n.id) || s1.RotationNumber(j.id, id, n.id) == 1 && !s1.Equal(id, j.id) {
+			continue
+		}
+		moved[k] = v
+		if n.replicas <= 1 {
+			delete(n.data, k)
+			n.version++
+		}
+	}
+	n.m.Unlock()
+	for k, v := range moved {
+		j.storeEntry(k, v)
+	}
+	if len(moved) > 0 {
+		// some of them may belong to nodes before j
+		j.setMisplaced()
+	}
+	return len(moved)
+}
+
+// replicaSet returns the first k-1 reachable successors of n.
+func (n *Node) replicaSet() []*Node {
+	var rs []*Node
+	for _, s := range n.succs() {
+		if len(rs) >= n.replicas-1 {
+			break
+		}
+		if s == nil || s == n || n.unreachable(s) {
+			continue
+		}
+		rs = append(rs, s)
+	}
+	return rs
+}
+
+// holds reports whether n stores id as its owner or a replica.
+func (n *Node) holds(id uint64) bool {
+	if p := n.pred(); s1.Equal(id, n.id) || p == nil || p == n || between(p.id, id, n.id) {
+		return true
+	}
+	if n.replicas <= 1 {
+		return false
+	}
+	o := n.locateSuccessor(id)
+	if o == nil || o == n {
+		return o == n
+	}
+	for _, r := range o.replicaSet() {
+		if r == n {
+			return true
+		}
+	}
+	return false
+}
+
+// handOver moves all keys of n to j.
+func (n *Node) handOver(j *Node) int {
+	n.m.Lock()
+	data := n.data
+	n.data = nil
+	n.version++
+	n.m.Unlock()
+	for k, v := range data {
+		j.storeEntry(k, v)
+	}
+	return len(data)
+}
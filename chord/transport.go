@@ -0,0 +1,87 @@
+package chord
+
+import (
+	"sync"
+	"time"
+)
+
+// Transport carries traffic between nodes.
+// Nodes ask it how far away their peers are when they choose fingers.
+type Transport interface {
+	// RTT returns the current round-trip estimate between two addresses.
+	// ok is false while no sample has been taken.
+	RTT(from, to string) (rtt time.Duration, ok bool)
+}
+
+// Partitioner is implemented by transports which may lose routes between peers,
+// e.g. a simulated network under partition. Nodes treat unreachable peers as failed.
+type Partitioner interface {
+	Reachable(from, to string) bool
+}
+
+// Prober is implemented by transports which take RTT samples on demand.
+// Nodes probe the candidates of a finger before they choose among them;
+// other transports have to take samples by themselves.
+type Prober interface {
+	Probe(from, to string)
+}
+
+// RTTEstimator keeps a smoothed round-trip estimate per pair of addresses.
+// It has no samples unless someone calls Observe: transports feed it with
+// the samples they measure, as Measured does, and answer RTT from it.
+type RTTEstimator struct {
+	// Alpha is the weight of a new sample (0 < Alpha <= 1).
+	// Zero means 1/8 as TCP does.
+	Alpha float64
+
+	m   sync.RWMutex
+	rtt map[[2]string]time.Duration
+}
+
+// Observe records a measured round-trip sample from one address to another.
+func (e *RTTEstimator) Observe(from, to string, sample time.Duration) {
+	a := e.Alpha
+	if a <= 0 || a > 1 {
+		a = 0.125
+	}
+	k := [2]string{from, to}
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.rtt == nil {
+		e.rtt = map[[2]string]time.Duration{}
+	}
+	old, ok := e.rtt[k]
+	if !ok {
+		e.rtt[k] = sample
+		return
+	}
+	e.rtt[k] = time.Duration((1-a)*float64(old) + a*float64(sample))
+}
+
+// RTT returns the smoothed estimate.
+func (e *RTTEstimator) RTT(from, to string) (time.Duration, bool) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	d, ok := e.rtt[[2]string{from, to}]
+	return d, ok
+}
+
+// Measured is a Transport which answers RTT from samples taken by Ping.
+// Nodes using it ping the candidates of fingers as they fix them.
+type Measured struct {
+	RTTEstimator
+	// Ping measures a round trip from one address to another.
+	Ping func(from, to string) (time.Duration, error)
+}
+
+var _ Prober = (*Measured)(nil)
+
+// Probe pings to from from and records the sample. Failed pings are ignored.
+func (m *Measured) Probe(from, to string) {
+	if m.Ping == nil {
+		return
+	}
+	if d, err := m.Ping(from, to); err == nil {
+		m.Observe(from, to, d)
+	}
+}
@@ -0,0 +1,228 @@
+package chord
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// KeyEventType is the kind of KeyEvent.
+type KeyEventType int
+
+const (
+	KeyPut KeyEventType = iota
+	KeyDeleted
+	KeyExpired
+)
+
+func (t KeyEventType) String() string {
+	switch t {
+	case KeyPut:
+		return "put"
+	case KeyDeleted:
+		return "delete"
+	case KeyExpired:
+		return "expire"
+	}
+	return fmt.Sprintf("KeyEventType(%d)", int(t))
+}
+
+// KeyEvent is a change of a stored key.
+type KeyEvent struct {
+	Type  KeyEventType
+	Key   string
+	Value []byte
+	// Stamp orders changes of a key.
+	Stamp int64
+	// Node is the address of the node which delivered the event.
+	Node string
+}
+
+// Arc is the arc (From, To] of the ring. From == To means the whole ring.
+type Arc struct {
+	From, To uint64
+}
+
+// Contains reports whether id is in a.
+func (a Arc) Contains(id uint64) bool {
+	return inArc(a.From, a.To, id)
+}
+
+// keyWatcher is a registration of a client on a node.
+type keyWatcher struct {
+	arc    Arc
+	key    string
+	hasKey bool
+	ch     chan<- KeyEvent
+	// moved is signaled when the node's arc changes
+	moved chan<- struct{}
+}
+
+func (n *Node) addKeyWatcher(w *keyWatcher) {
+	n.em.Lock()
+	defer n.em.Unlock()
+	if n.keyWatchers == nil {
+		n.keyWatchers = map[*keyWatcher]struct{}{}
+	}
+	n.keyWatchers[w] = struct{}{}
+}
+
+func (n *Node) removeKeyWatcher(w *keyWatcher) {
+	n.em.Lock()
+	defer n.em.Unlock()
+	delete(n.keyWatchers, w)
+}
+
+// keyChanged delivers the change of key to watchers.
+// Like Subscribe, events are dropped while a watcher's buffer is full.
+func (n *Node) keyChanged(key string, e entry) {
+	ev := KeyEvent{Type: KeyPut, Key: key, Value: e.value, Stamp: e.stamp, Node: n.addr}
+	switch {
+	case e.deleted:
+		ev.Type = KeyDeleted
+	case e.tomb:
+		ev.Type = KeyExpired
+	}
+	id := n.Hash(key)
+	n.em.Lock()
+	defer n.em.Unlock()
+	for w := range n.keyWatchers {
+		if w.hasKey && w.key != key || !w.arc.Contains(id) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+		}
+	}
+}
+
+func (n *Node) keysMoved() {
+	n.em.Lock()
+	defer n.em.Unlock()
+	for w := range n.keyWatchers {
+		select {
+		case w.moved <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watchBuffer is the buffer of watch channels.
+const watchBuffer = 64
+
+// defaultWatchInterval is how often watches look for owners when Client.WatchInterval is zero.
+const defaultWatchInterval = time.Second
+
+// watchMemory is how many intervals a watch remembers the last change of a
+// key to drop duplicates; copies from replicas and new owners come within it.
+const watchMemory = 64
+
+// Watch returns a channel of changes of key until ctx is done.
+// Events come from the owner of key; Watch registers on a new owner
+// when ownership moves by joins or failures. A change may be missed
+// while the owner moves, and a change is not delivered twice unless it
+// comes again watchMemory intervals after the last change of the key.
+func (c *Client) Watch(ctx context.Context, key string) (<-chan KeyEvent, error) {
+	id := c.Node.Hash(key)
+	return c.watch(ctx, &keyWatcher{arc: Arc{id - 1, id}, key: key, hasKey: true})
+}
+
+// WatchRange returns a channel of changes of keys in a until ctx is done.
+// It registers on every owner of a, as Watch does.
+func (c *Client) WatchRange(ctx context.Context, a Arc) (<-chan KeyEvent, error) {
+	return c.watch(ctx, &keyWatcher{arc: a})
+}
+
+func (c *Client) watch(ctx context.Context, filter *keyWatcher) (<-chan KeyEvent, error) {
+	owners, err := c.owners(filter.arc)
+	if err != nil {
+		return nil, err
+	}
+	in, moved := make(chan KeyEvent, watchBuffer), make(chan struct{}, 1)
+	regs := map[*Node]*keyWatcher{}
+	register := func(owners []*Node) {
+		current := map[*Node]bool{}
+		for _, o := range owners {
+			current[o] = true
+			if regs[o] == nil {
+				w := *filter
+				w.ch, w.moved = in, moved
+				o.addKeyWatcher(&w)
+				regs[o] = &w
+			}
+		}
+		for o, w := range regs {
+			if !current[o] {
+				o.removeKeyWatcher(w)
+				delete(regs, o)
+			}
+		}
+	}
+	register(owners)
+
+	interval := c.WatchInterval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	out := make(chan KeyEvent, watchBuffer)
+	go func() {
+		defer close(out)
+		defer register(nil)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		// the last change of each key and when it came; expiry keeps the stamp of its put
+		type change struct {
+			KeyEvent
+			at time.Time
+		}
+		seen := map[string]change{}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				for k, last := range seen {
+					if now.Sub(last.at) > watchMemory*interval {
+						delete(seen, k)
+					}
+				}
+			case <-moved:
+			case e := <-in:
+				if last, ok := seen[e.Key]; ok && (e.Stamp < last.Stamp || e.Stamp == last.Stamp && e.Type <= last.Type) {
+					continue
+				}
+				seen[e.Key] = change{KeyEvent{Type: e.Type, Stamp: e.Stamp}, time.Now()}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			if owners, err := c.owners(filter.arc); err == nil {
+				register(owners)
+			}
+		}
+	}()
+	return out, nil
+}
+
+// owners returns nodes responsible for a in ring order.
+func (c *Client) owners(a Arc) ([]*Node, error) {
+	o, _, err := c.Node.Lookup(a.From + 1)
+	if err != nil {
+		return nil, err
+	}
+	owners := []*Node{o}
+	for len(owners) < maxRingView && between(a.From, o.id, a.To) {
+		if o, _, err = c.Node.Lookup(o.id + 1); err != nil {
+			return nil, err
+		}
+		if o == owners[0] {
+			break
+		}
+		owners = append(owners, o)
+	}
+	return owners, nil
+}
@@ -0,0 +1,154 @@
+package chord
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func nextKeyEvent(t *testing.T, ch <-chan KeyEvent) KeyEvent {
+	t.Helper()
+	select {
+	case e, ok := <-ch:
+		if !ok {
+			t.Fatal("watch was closed")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event was delivered")
+	}
+	return KeyEvent{}
+}
+
+func noKeyEvent(t *testing.T, ch <-chan KeyEvent) {
+	t.Helper()
+	select {
+	case e := <-ch:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatch(t *testing.T) {
+	defer func() { now = time.Now }()
+	advance := setClock(time.Unix(1000, 0))
+
+	ring := replicatedRing()
+	c := &Client{Node: ring[0], WatchInterval: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := c.Watch(ctx, "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("5", strings.NewReader("v1"))
+	if e := nextKeyEvent(t, ch); e.Type != KeyPut || e.Key != "5" || string(e.Value) != "v1" || e.Node != "10" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	c.Put("6", strings.NewReader("other key"))
+	c.Delete("5")
+	if e := nextKeyEvent(t, ch); e.Type != KeyDeleted {
+		t.Errorf("unexpected event %+v", e)
+	}
+	c.PutTTL("5", strings.NewReader("v2"), time.Minute)
+	nextKeyEvent(t, ch)
+	advance(2 * time.Minute)
+	ring[1].collectExpired()
+	if e := nextKeyEvent(t, ch); e.Type != KeyExpired || e.Key != "5" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	noKeyEvent(t, ch)
+
+	// a new node takes over key 5 from ring[1]
+	late := NewNode("8", 4, generateTestHash(64))
+	late.SetReplicationFactor(3)
+	late.joinRing(ring[0])
+	for i := 0; i < 3; i++ {
+		for _, n := range append(ring, late) {
+			n.stabilize()
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(watchersOf(late)) == 0 || len(watchersOf(ring[1])) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("watch didn't move to the new owner")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Put("5", strings.NewReader("v3"))
+	if e := nextKeyEvent(t, ch); string(e.Value) != "v3" || e.Node != "8" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	cancel()
+	for range ch {
+	}
+	if len(watchersOf(late)) != 0 {
+		t.Errorf("watch was not unregistered")
+	}
+}
+
+func watchersOf(n *Node) map[*keyWatcher]struct{} {
+	n.em.Lock()
+	defer n.em.Unlock()
+	ws := map[*keyWatcher]struct{}{}
+	for w := range n.keyWatchers {
+		ws[w] = struct{}{}
+	}
+	return ws
+}
+
+func TestWatchRange(t *testing.T) {
+	ring := replicatedRing()
+	c := &Client{Node: ring[0]}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := c.WatchRange(ctx, Arc{0, 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range ring {
+		if ws := watchersOf(n); (len(ws) == 1) != (n == ring[1] || n == ring[2]) {
+			t.Errorf("id:%d has %d watchers", n.id, len(ws))
+		}
+	}
+	for _, k := range []string{"21", "5", "1f"} {
+		c.Put(k, strings.NewReader(k))
+	}
+	// replicas deliver the same change once
+	for _, k := range []string{"5", "1f"} {
+		if e := nextKeyEvent(t, ch); e.Key != k {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+	noKeyEvent(t, ch)
+}
+
+// TestWatchWhileMaintained looks for owners while maintenance rewrites
+// routing state; run it with -race.
+func TestWatchWhileMaintained(t *testing.T) {
+	ring := replicatedRing()
+	c := &Client{Node: ring[0], WatchInterval: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := c.WatchRange(ctx, Arc{0, 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			for _, n := range ring {
+				n.Maintain()
+			}
+		}
+	}()
+	<-done
+	c.Put("5", strings.NewReader("v"))
+	if e := nextKeyEvent(t, ch); e.Key != "5" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	cancel()
+	for range ch {
+	}
+}
@@ -0,0 +1,111 @@
+package chord
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// TestZaveInterleavings runs random schedules of join, stabilize and fail
+// on small rings. Safety invariants must hold after every step and the ring
+// must converge once churn stops.
+func TestZaveInterleavings(t *testing.T) {
+	const (
+		space    = 64
+		r        = 3
+		schedule = 40
+	)
+	for seed := int64(0); seed < 300; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		ids := rnd.Perm(space)
+		var members, pending []*Node
+		for i, id := range ids[:r+5] {
+			n := NewNode(fmt.Sprintf("%x", id), 5, generateTestHash(space))
+			n.SetSuccessorListLength(r)
+			if i <= r {
+				members = append(members, n)
+			} else {
+				pending = append(pending, n)
+			}
+		}
+		sortNodes(members)
+		setupRingStatically(members, r)
+
+		var trace []string
+		fatal := func(err error) {
+			t.Fatalf("seed %d: %s\ntrace: %v", seed, err, trace)
+		}
+		for step := 0; step < schedule; step++ {
+			live := aliveNodes(members)
+			switch op := rnd.Intn(4); {
+			case op == 0 && len(pending) > 0:
+				n, via := pending[0], live[rnd.Intn(len(live))]
+				pending = pending[1:]
+				trace = append(trace, fmt.Sprintf("join(%d via %d)", n.id, via.id))
+				if err := n.joinRing(via); err != nil {
+					fatal(err)
+				}
+				members = append(members, n)
+			case op == 1:
+				n := live[rnd.Intn(len(live))]
+				if !failureAllowed(members, n) {
+					continue
+				}
+				trace = append(trace, fmt.Sprintf("fail(%d)", n.id))
+				n.Fail()
+			default:
+				n := live[rnd.Intn(len(live))]
+				trace = append(trace, fmt.Sprintf("stabilize(%d)", n.id))
+				n.stabilize()
+			}
+			if err := checkRingInvariants(members); err != nil {
+				fatal(err)
+			}
+		}
+		for round := 0; round < 2*len(members); round++ {
+			for _, n := range aliveNodes(members) {
+				n.stabilize()
+			}
+			if err := checkRingInvariants(members); err != nil {
+				fatal(err)
+			}
+		}
+		if err := checkRingConverged(members); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// failureAllowed keeps the failure assumption of the protocol:
+// every alive node keeps an alive entry in its successor list.
+func failureAllowed(nodes []*Node, f *Node) bool {
+	live := aliveNodes(nodes)
+	if len(live) <= 2 {
+		return false
+	}
+	for _, n := range live {
+		if n == f {
+			continue
+		}
+		ok := false
+		for _, s := range n.successors {
+			if s != nil && s != f && !s.fail() {
+				ok = true
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func sortNodes(nodes []*Node) {
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			if nodes[j].id < nodes[i].id {
+				nodes[i], nodes[j] = nodes[j], nodes[i]
+			}
+		}
+	}
+}
@@ -11,7 +11,7 @@ import (
 // WithCancelBySignal returns context cancelable by signal
 func WithCancelBySignal(parent context.Context, sigs ...os.Signal) (ctx context.Context) {
 	ctx, cancel := context.WithCancel(parent)
-	sc := make(chan os.Signal)
+	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, sigs...)
 	go func() {
 		select {
@@ -0,0 +1,146 @@
+// Package dhtbench compares DHT routing geometries on the same workload.
+package dhtbench
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+)
+
+// Overlay is a DHT under measurement.
+// Members are named by ids in the shared 64bit key space.
+type Overlay interface {
+	Name() string
+	// Add creates member id and joins it through an alive member.
+	Add(id uint64, via uint64) error
+	// Fail crashes member id.
+	Fail(id uint64)
+	// Maintain runs one period of maintenance at every alive member.
+	Maintain()
+	// Lookup locates key starting from member from.
+	Lookup(from, key uint64) (owner uint64, hops int, err error)
+	// Owner returns the member responsible for key among alive ones.
+	Owner(key uint64, alive []uint64) uint64
+}
+
+// Config is a workload.
+type Config struct {
+	Nodes     int
+	Lookups   int
+	FailRatio float64
+	// Rounds is the number of maintenance periods after joins and after failures.
+	Rounds int
+	Seed   int64
+}
+
+// Result is a measurement of an Overlay.
+type Result struct {
+	Overlay string
+	Nodes   int
+	// Hops[i] is the number of successful lookups which took i hops.
+	Hops     []int
+	MeanHops float64
+	// Success is the ratio of lookups answered by the right member.
+	Success float64
+	// Churned is Success after FailRatio of members failed.
+	Churned float64
+}
+
+// Run measures o under c.
+func Run(o Overlay, c Config) Result {
+	r := rand.New(rand.NewSource(c.Seed))
+	alive := make([]uint64, 0, c.Nodes)
+	seen := map[uint64]bool{}
+	for len(alive) < c.Nodes {
+		id := r.Uint64()
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		via := id
+		if len(alive) > 0 {
+			via = alive[r.Intn(len(alive))]
+		}
+		if o.Add(id, via) == nil {
+			alive = append(alive, id)
+		}
+	}
+	for i := 0; i < c.Rounds; i++ {
+		o.Maintain()
+	}
+	res := Result{Overlay: o.Name(), Nodes: len(alive)}
+	var hops []int
+	res.Success, hops = measure(o, r, alive, c.Lookups)
+	res.Hops = histogram(hops)
+	res.MeanHops = mean(hops)
+
+	r.Shuffle(len(alive), func(i, j int) { alive[i], alive[j] = alive[j], alive[i] })
+	failed := int(float64(len(alive)) * c.FailRatio)
+	for _, id := range alive[:failed] {
+		o.Fail(id)
+	}
+	alive = alive[failed:]
+	for i := 0; i < c.Rounds; i++ {
+		o.Maintain()
+	}
+	res.Churned, _ = measure(o, r, alive, c.Lookups)
+	return res
+}
+
+func measure(o Overlay, r *rand.Rand, alive []uint64, lookups int) (float64, []int) {
+	if lookups == 0 || len(alive) == 0 {
+		return 0, nil
+	}
+	sorted := append([]uint64{}, alive...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	ok := 0
+	var hops []int
+	for i := 0; i < lookups; i++ {
+		key := r.Uint64()
+		owner, h, err := o.Lookup(alive[r.Intn(len(alive))], key)
+		if err != nil || owner != o.Owner(key, sorted) {
+			continue
+		}
+		ok++
+		hops = append(hops, h)
+	}
+	return float64(ok) / float64(lookups), hops
+}
+
+func histogram(hops []int) []int {
+	var hist []int
+	for _, h := range hops {
+		for len(hist) <= h {
+			hist = append(hist, 0)
+		}
+		hist[h]++
+	}
+	return hist
+}
+
+func mean(hops []int) float64 {
+	if len(hops) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, h := range hops {
+		sum += h
+	}
+	return float64(sum) / float64(len(hops))
+}
+
+// WriteTable writes results as a text table.
+func WriteTable(w io.Writer, rs ...Result) error {
+	if _, err := fmt.Fprintf(w, "%-10s %6s %9s %8s %8s  %s\n",
+		"overlay", "nodes", "mean-hops", "success", "churned", "hops"); err != nil {
+		return err
+	}
+	for _, r := range rs {
+		if _, err := fmt.Fprintf(w, "%-10s %6d %9.2f %8.3f %8.3f  %v\n",
+			r.Overlay, r.Nodes, r.MeanHops, r.Success, r.Churned, r.Hops); err != nil {
+			return err
+		}
+	}
+	return nil
+}
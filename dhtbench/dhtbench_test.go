@@ -0,0 +1,64 @@
+package dhtbench
+
+import (
+	"bytes"
+	"testing"
+)
+
+// chord fixes one of 63 fingers per period, so it takes two passes to settle.
+const rounds = 130
+
+func TestCompare(t *testing.T) {
+	c := Config{Nodes: 64, Lookups: 200, FailRatio: 0.2, Rounds: rounds, Seed: 1}
+	var rs []Result
+	for _, o := range []Overlay{NewChord(), NewKademlia(4, 3)} {
+		r := Run(o, c)
+		if r.Success != 1 {
+			t.Errorf("%s: lookups failed on stable network: %+v", r.Overlay, r)
+		}
+		if r.MeanHops == 0 {
+			t.Errorf("%s: no hops measured: %+v", r.Overlay, r)
+		}
+		if r.Churned < 0.95 {
+			t.Errorf("%s: lookups failed after churn: %+v", r.Overlay, r)
+		}
+		rs = append(rs, r)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := WriteTable(buf, rs...); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + buf.String())
+}
+
+func TestChurn(t *testing.T) {
+	// repairs must not depend on which members happen to fail
+	for seed := int64(2); seed < 6; seed++ {
+		c := Config{Nodes: 64, Lookups: 200, FailRatio: 0.2, Rounds: rounds, Seed: seed}
+		for _, o := range []Overlay{NewChord(), NewKademlia(4, 3)} {
+			r := Run(o, c)
+			if r.Churned < 0.95 {
+				t.Errorf("seed %d: %s: lookups failed after churn: %+v", seed, r.Overlay, r)
+			}
+		}
+	}
+}
+
+func BenchmarkChord(b *testing.B) {
+	benchmarkOverlay(b, NewChord)
+}
+
+func BenchmarkKademlia(b *testing.B) {
+	benchmarkOverlay(b, func() Overlay { return NewKademlia(8, 3) })
+}
+
+func benchmarkOverlay(b *testing.B, o func() Overlay) {
+	c := Config{Nodes: 128, Lookups: 1000, FailRatio: 0.2, Rounds: rounds, Seed: 1}
+	var r Result
+	for i := 0; i < b.N; i++ {
+		r = Run(o(), c)
+	}
+	b.ReportMetric(r.MeanHops, "hops/lookup")
+	b.ReportMetric(r.Success, "success")
+	b.ReportMetric(r.Churned, "churned-success")
+}
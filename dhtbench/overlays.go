@@ -0,0 +1,123 @@
+package dhtbench
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/masu-mi/gimmick.git/chord"
+	"github.com/masu-mi/gimmick.git/kademlia"
+)
+
+// idHash maps addresses written as hex ids to themselves.
+func idHash(k string) uint64 {
+	u, err := strconv.ParseUint(k, 16, 64)
+	if err != nil {
+		return chord.DefaultHash(k)
+	}
+	return u
+}
+
+func addr(id uint64) string {
+	return fmt.Sprintf("%x", id)
+}
+
+type chordOverlay struct {
+	nodes map[uint64]*chord.Node
+	order []uint64
+}
+
+// chordSuccessors is r of chord members.
+const chordSuccessors = 8
+
+// NewChord returns Overlay of chord ring.
+func NewChord() Overlay {
+	return &chordOverlay{nodes: map[uint64]*chord.Node{}}
+}
+
+func (o *chordOverlay) Name() string { return "chord" }
+func (o *chordOverlay) Add(id, via uint64) error {
+	// finger i starts at id+2^(i+1), so 62 covers the whole 64bit space
+	n := chord.NewNode(addr(id), 62, idHash)
+	// r of O(log N) keeps the ring when 20% fail; with 3 a run of 3 adjacent
+	// failures among 64 members is likely
+	n.SetSuccessorListLength(chordSuccessors)
+	if id == via {
+		n.Create()
+	} else if err := n.Join(o.nodes[via]); err != nil {
+		return err
+	}
+	o.nodes[id] = n
+	o.order = append(o.order, id)
+	return nil
+}
+func (o *chordOverlay) Fail(id uint64) {
+	o.nodes[id].Fail()
+}
+func (o *chordOverlay) Maintain() {
+	for _, id := range o.order {
+		o.nodes[id].Maintain()
+	}
+}
+func (o *chordOverlay) Lookup(from, key uint64) (uint64, int, error) {
+	s, hops, err := o.nodes[from].Lookup(key)
+	if err != nil {
+		return 0, hops, err
+	}
+	return s.ID(), hops, nil
+}
+func (o *chordOverlay) Owner(key uint64, alive []uint64) uint64 {
+	i := sort.Search(len(alive), func(i int) bool { return alive[i] >= key })
+	if i == len(alive) {
+		return alive[0]
+	}
+	return alive[i]
+}
+
+type kademliaOverlay struct {
+	k, alpha int
+	nodes    map[uint64]*kademlia.Node
+	order    []uint64
+}
+
+// NewKademlia returns Overlay of kademlia network.
+func NewKademlia(k, alpha int) Overlay {
+	return &kademliaOverlay{k: k, alpha: alpha, nodes: map[uint64]*kademlia.Node{}}
+}
+
+func (o *kademliaOverlay) Name() string { return "kademlia" }
+func (o *kademliaOverlay) Add(id, via uint64) error {
+	n := kademlia.NewNode(addr(id), o.k, o.alpha, idHash)
+	if id != via {
+		if err := n.Join(o.nodes[via]); err != nil {
+			return err
+		}
+	}
+	o.nodes[id] = n
+	o.order = append(o.order, id)
+	return nil
+}
+func (o *kademliaOverlay) Fail(id uint64) {
+	o.nodes[id].Fail()
+}
+func (o *kademliaOverlay) Maintain() {
+	for _, id := range o.order {
+		o.nodes[id].Maintain()
+	}
+}
+func (o *kademliaOverlay) Lookup(from, key uint64) (uint64, int, error) {
+	n, hops, err := o.nodes[from].Lookup(key)
+	if err != nil {
+		return 0, hops, err
+	}
+	return n.ID(), hops, nil
+}
+func (o *kademliaOverlay) Owner(key uint64, alive []uint64) uint64 {
+	owner := alive[0]
+	for _, id := range alive[1:] {
+		if id^key < owner^key {
+			owner = id
+		}
+	}
+	return owner
+}
@@ -0,0 +1,385 @@
+package kademlia
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/masu-mi/gimmick.git/chord"
+)
+
+// Node is Kademlia node.
+// It places keys on the nodes closest to them in XOR metric
+// and serves the same StorageService as chord.
+type Node struct {
+	addr string
+	id   uint64
+
+	hash func(string) uint64
+
+	k     int
+	alpha int
+
+	m          sync.Mutex
+	buckets    [64][]*Node
+	store      map[string][]byte
+	nextBucket int
+
+	failed int32
+}
+
+const (
+	// DefaultK is the size of k-bucket and the replication factor.
+	DefaultK = 8
+	// DefaultAlpha is the number of parallel queries in a lookup.
+	DefaultAlpha = 3
+)
+
+var (
+	ErrEmptyNode  = chord.ErrEmptyNode
+	ErrNodeFailed = chord.ErrNodeFailed
+	ErrNotFound   = errors.New("key not found")
+)
+
+var _ chord.StorageService = (*Node)(nil)
+
+// NewNode creates Node which knows no other nodes.
+// Zero k or alpha means the defaults; nil hash means chord's one.
+func NewNode(addr string, k, alpha int, hash func(string) uint64) *Node {
+	if k <= 0 {
+		k = DefaultK
+	}
+	if alpha <= 0 {
+		alpha = DefaultAlpha
+	}
+	n := &Node{
+		addr: addr, hash: hash,
+		k: k, alpha: alpha,
+		store: map[string][]byte{},
+	}
+	n.id = n.Hash(addr)
+	return n
+}
+
+// Hash changes input key string to id in logical key space.
+func (n *Node) Hash(k string) uint64 {
+	if n == nil || n.hash == nil {
+		return chord.DefaultHash(k)
+	}
+	return n.hash(k)
+}
+
+// ID returns n's id in logical key space.
+func (n *Node) ID() uint64 {
+	return n.id
+}
+
+// Addr returns n's address.
+func (n *Node) Addr() string {
+	return n.addr
+}
+
+// Fail makes n crash. Peers see it failed from now on.
+// It is for simulations; real nodes fail by themselves.
+func (n *Node) Fail() {
+	atomic.StoreInt32(&n.failed, 1)
+}
+
+func (n *Node) fail() bool {
+	return atomic.LoadInt32(&n.failed) == 1
+}
+
+func distance(a, b uint64) uint64 {
+	return a ^ b
+}
+
+// bucketIndex returns index of k-bucket covering distance d (d > 0).
+// Bucket i holds nodes in distance [2^i, 2^(i+1)).
+func bucketIndex(d uint64) int {
+	return 63 - bits.LeadingZeros64(d)
+}
+
+// update records c as recently seen.
+// A full bucket keeps its least recently seen node while it is alive.
+func (n *Node) update(c *Node) {
+	if c == nil || c == n || c.id == n.id {
+		return
+	}
+	n.m.Lock()
+	defer n.m.Unlock()
+	i := bucketIndex(distance(n.id, c.id))
+	b := n.buckets[i]
+	for j, e := range b {
+		if e == c {
+			n.buckets[i] = append(append(b[:j:j], b[j+1:]...), c)
+			return
+		}
+	}
+	if len(b) < n.k {
+		n.buckets[i] = append(b, c)
+		return
+	}
+	if head := b[0]; head.fail() {
+		n.buckets[i] = append(b[1:len(b):len(b)], c)
+	} else {
+		n.buckets[i] = append(b[1:len(b):len(b)], head)
+	}
+}
+
+// remove forgets c.
+func (n *Node) remove(c *Node) {
+	if c == nil || c == n || c.id == n.id {
+		// n keeps no bucket for its own id
+		return
+	}
+	n.m.Lock()
+	defer n.m.Unlock()
+	i := bucketIndex(distance(n.id, c.id))
+	b := n.buckets[i]
+	for j, e := range b {
+		if e == c {
+			n.buckets[i] = append(b[:j:j], b[j+1:]...)
+			return
+		}
+	}
+}
+
+// closest returns at most count known nodes closest to id.
+func (n *Node) closest(id uint64, count int) []*Node {
+	n.m.Lock()
+	var all []*Node
+	for _, b := range n.buckets {
+		all = append(all, b...)
+	}
+	n.m.Unlock()
+	sortByDistance(all, id)
+	if len(all) > count {
+		all = all[:count]
+	}
+	return all
+}
+
+func sortByDistance(nodes []*Node, id uint64) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return distance(nodes[i].id, id) < distance(nodes[j].id, id)
+	})
+}
+
+// findNode is FIND_NODE RPC from.
+func (n *Node) findNode(id uint64, from *Node) ([]*Node, error) {
+	if n.fail() {
+		return nil, ErrNodeFailed
+	}
+	n.update(from)
+	return n.closest(id, n.k), nil
+}
+
+// Join makes n a member of the network j belongs to.
+func (n *Node) Join(j *Node) error {
+	if j == nil {
+		return ErrEmptyNode
+	}
+	if j.fail() {
+		return ErrNodeFailed
+	}
+	n.update(j)
+	if _, _, err := n.lookup(n.id); err != nil {
+		return err
+	}
+	n.Refresh()
+	return nil
+}
+
+// Refresh looks up an id in each non-empty bucket's range
+// to fill neighbouring buckets and to drop failed nodes.
+func (n *Node) Refresh() {
+	if n.fail() {
+		return
+	}
+	for i := 0; i < len(n.buckets); i++ {
+		n.m.Lock()
+		empty := len(n.buckets[i]) == 0
+		n.m.Unlock()
+		if empty {
+			continue
+		}
+		n.lookup(n.id ^ (1 << uint(i)))
+	}
+}
+
+// Maintain refreshes the next non-empty bucket.
+// It is executed periodically like chord's maintenance.
+func (n *Node) Maintain() {
+	if n.fail() {
+		return
+	}
+	for i := 0; i < len(n.buckets); i++ {
+		n.m.Lock()
+		b := n.nextBucket
+		n.nextBucket = (n.nextBucket + 1) % len(n.buckets)
+		empty := len(n.buckets[b]) == 0
+		n.m.Unlock()
+		if !empty {
+			n.lookup(n.id ^ (1 << uint(b)))
+			return
+		}
+	}
+}
+
+// Lookup returns the alive node closest to id and the number of rounds it took.
+func (n *Node) Lookup(id uint64) (*Node, int, error) {
+	nodes, hops, err := n.lookup(id)
+	if err != nil {
+		return nil, hops, err
+	}
+	return nodes[0], hops, nil
+}
+
+// lookup finds k closest alive nodes to id.
+// Each round queries alpha closest unqueried nodes in parallel
+// and ends when the k closest have answered.
+func (n *Node) lookup(id uint64) ([]*Node, int, error) {
+	if n == nil {
+		return nil, 0, ErrEmptyNode
+	}
+	if n.fail() {
+		return nil, 0, ErrNodeFailed
+	}
+	shortlist := append([]*Node{n}, n.closest(id, n.k)...)
+	sortByDistance(shortlist, id)
+	queried := map[*Node]bool{n: true}
+	seen := map[*Node]bool{}
+	for _, c := range shortlist {
+		seen[c] = true
+	}
+	hops := 0
+	for {
+		var round []*Node
+		for _, c := range shortlist {
+			if len(round) == n.alpha {
+				break
+			}
+			if !queried[c] {
+				round = append(round, c)
+			}
+		}
+		if len(round) == 0 {
+			break
+		}
+		hops++
+		type answer struct {
+			from  *Node
+			nodes []*Node
+			err   error
+		}
+		answers := make([]answer, len(round))
+		wg := sync.WaitGroup{}
+		for i, c := range round {
+			queried[c] = true
+			wg.Add(1)
+			go func(i int, c *Node) {
+				defer wg.Done()
+				nodes, err := c.findNode(id, n)
+				answers[i] = answer{from: c, nodes: nodes, err: err}
+			}(i, c)
+		}
+		wg.Wait()
+		failed := map[*Node]bool{}
+		for _, a := range answers {
+			if a.err != nil {
+				failed[a.from] = true
+				n.remove(a.from)
+				continue
+			}
+			n.update(a.from)
+			for _, c := range a.nodes {
+				// another node of n's id is a stale or conflicting one; it is never queried
+				if !seen[c] && c.id != n.id {
+					seen[c] = true
+					shortlist = append(shortlist, c)
+				}
+			}
+		}
+		alive := shortlist[:0]
+		for _, c := range shortlist {
+			if !failed[c] {
+				alive = append(alive, c)
+			}
+		}
+		shortlist = alive
+		sortByDistance(shortlist, id)
+		if len(shortlist) > n.k {
+			shortlist = shortlist[:n.k]
+		}
+	}
+	if len(shortlist) == 0 {
+		return nil, hops, ErrEmptyNode
+	}
+	return shortlist, hops, nil
+}
+
+// Put stores value on k nodes closest to key.
+func (n *Node) Put(key string, value io.Reader) error {
+	b, err := ioutil.ReadAll(value)
+	if err != nil {
+		return err
+	}
+	nodes, _, err := n.lookup(n.Hash(key))
+	if err != nil {
+		return err
+	}
+	stored := 0
+	for _, c := range nodes {
+		if c.storeValue(key, b) == nil {
+			stored++
+		}
+	}
+	if stored == 0 {
+		return ErrNodeFailed
+	}
+	return nil
+}
+
+// Get returns value stored on the closest node which has key.
+func (n *Node) Get(key string) (io.ReadCloser, error) {
+	nodes, _, err := n.lookup(n.Hash(key))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range nodes {
+		if b, err := c.findValue(key); err == nil {
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// storeValue is STORE RPC.
+func (n *Node) storeValue(key string, value []byte) error {
+	if n.fail() {
+		return ErrNodeFailed
+	}
+	n.m.Lock()
+	n.store[key] = value
+	n.m.Unlock()
+	return nil
+}
+
+// findValue is FIND_VALUE RPC answering from local store only.
+func (n *Node) findValue(key string) ([]byte, error) {
+	if n.fail() {
+		return nil, ErrNodeFailed
+	}
+	n.m.Lock()
+	defer n.m.Unlock()
+	b, ok := n.store[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
@@ -0,0 +1,199 @@
+package kademlia
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+func createTestKey(id uint64) string {
+	return fmt.Sprintf("%x", id)
+}
+
+// TestLookup
+func TestLookup(t *testing.T) {
+	type testCase struct {
+		inputKey   string
+		expectedID uint64
+	}
+	type test struct {
+		title    string
+		nodes    []*Node
+		testCase []testCase
+	}
+	for _, set := range []test{
+		test{
+			title: "one node(id:0)",
+			nodes: generateNodes(1, 0, 1),
+			testCase: []testCase{
+				testCase{inputKey: createTestKey(0), expectedID: 0},
+				testCase{inputKey: createTestKey(1), expectedID: 0},
+				testCase{inputKey: createTestKey(7), expectedID: 0},
+			},
+		},
+		test{
+			title: "2 nodes(id:0,4)",
+			nodes: generateNodes(2, 0, 4),
+			testCase: []testCase{
+				testCase{inputKey: createTestKey(0), expectedID: 0},
+				testCase{inputKey: createTestKey(3), expectedID: 0},
+				testCase{inputKey: createTestKey(4), expectedID: 4},
+				testCase{inputKey: createTestKey(7), expectedID: 4},
+				testCase{inputKey: createTestKey(8), expectedID: 0},
+				testCase{inputKey: createTestKey(12), expectedID: 4},
+			},
+		},
+		test{
+			title: "4 nodes(id:1,4,7,10)",
+			nodes: generateNodes(4, 1, 3),
+			testCase: []testCase{
+				testCase{inputKey: createTestKey(0), expectedID: 1},
+				testCase{inputKey: createTestKey(2), expectedID: 1},
+				testCase{inputKey: createTestKey(5), expectedID: 4},
+				testCase{inputKey: createTestKey(6), expectedID: 7},
+				testCase{inputKey: createTestKey(8), expectedID: 10},
+				testCase{inputKey: createTestKey(14), expectedID: 10},
+				testCase{inputKey: createTestKey(15), expectedID: 10},
+			},
+		},
+		test{
+			title: "16 nodes(id:0,2,...,30)",
+			nodes: generateNodes(16, 0, 2),
+			testCase: []testCase{
+				testCase{inputKey: createTestKey(1), expectedID: 0},
+				testCase{inputKey: createTestKey(17), expectedID: 16},
+				testCase{inputKey: createTestKey(30), expectedID: 30},
+				testCase{inputKey: createTestKey(31), expectedID: 30},
+			},
+		},
+	} {
+		joinAll(set.nodes)
+		t.Run(set.title, func(t *testing.T) {
+			for _, n := range set.nodes {
+				for _, c := range set.testCase {
+					act, _, err := n.Lookup(n.Hash(c.inputKey))
+					if err != nil {
+						t.Errorf("error(%s) was returned with starting from id:%d; expectedID:%d, key:%s",
+							err, n.id, c.expectedID, c.inputKey)
+					} else if act.id != c.expectedID {
+						t.Errorf("invalid node returned(id:%d) with starting from id:%d; expectedID:%d, key:%s",
+							act.id, n.id, c.expectedID, c.inputKey)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBucketIndex(t *testing.T) {
+	for _, c := range []struct {
+		a, b     uint64
+		expected int
+	}{
+		{a: 0, b: 1, expected: 0},
+		{a: 0, b: 2, expected: 1},
+		{a: 2, b: 3, expected: 0},
+		{a: 4, b: 7, expected: 1},
+		{a: 8, b: 7, expected: 3},
+		{a: 0, b: 1 << 63, expected: 63},
+	} {
+		if act := bucketIndex(distance(c.a, c.b)); act != c.expected {
+			t.Errorf("bucket of %d for %d is %d; expected %d", c.b, c.a, act, c.expected)
+		}
+	}
+}
+
+func TestUpdateKeepsLiveOldNodes(t *testing.T) {
+	n := NewNode("0", 2, 1, testHash)
+	// all of them are in bucket 2
+	old, mid, fresh := NewNode("4", 0, 0, testHash), NewNode("5", 0, 0, testHash), NewNode("6", 0, 0, testHash)
+	n.update(old)
+	n.update(mid)
+	n.update(fresh)
+	if act := n.closest(4, 3); len(act) != 2 || act[0] != old {
+		t.Errorf("live least recently seen node was evicted: %+v", act)
+	}
+	old.Fail()
+	// mid is the least recently seen one now, then old is checked
+	n.update(fresh)
+	n.update(fresh)
+	for _, c := range n.closest(4, 3) {
+		if c == old {
+			t.Errorf("failed node was kept")
+		}
+	}
+}
+
+func TestLookupThroughNodeOfSameID(t *testing.T) {
+	n, peer, twin := NewNode("0", 2, 1, testHash), NewNode("4", 2, 1, testHash), NewNode("0", 2, 1, testHash)
+	n.update(peer)
+	peer.update(twin)
+	twin.Fail()
+	nodes, _, err := n.lookup(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range nodes {
+		if c == twin {
+			t.Errorf("lookup returned the node of the same id")
+		}
+	}
+	// a failure of it is ignored as well
+	n.remove(twin)
+}
+
+func TestPutGet(t *testing.T) {
+	nodes := generateNodes(16, 0, 2)
+	joinAll(nodes)
+	for i, n := range nodes {
+		key := createTestKey(uint64(i * 3))
+		if err := n.Put(key, bytes.NewBufferString("v"+key)); err != nil {
+			t.Fatalf("put %s: %s", key, err)
+		}
+	}
+	// replicas survive failures of the closest nodes
+	nodes[0].Fail()
+	nodes[7].Fail()
+	for i := range nodes {
+		key := createTestKey(uint64(i * 3))
+		r, err := nodes[len(nodes)-1].Get(key)
+		if err != nil {
+			t.Errorf("get %s: %s", key, err)
+			continue
+		}
+		b, _ := ioutil.ReadAll(r)
+		if string(b) != "v"+key {
+			t.Errorf("get %s: %s", key, b)
+		}
+	}
+	if _, err := nodes[2].Get("ffff"); err != ErrNotFound {
+		t.Errorf("unknown key returned %v", err)
+	}
+}
+
+func testHash(k string) uint64 {
+	u, err := strconv.ParseUint(k, 16, 64)
+	if err != nil {
+		return 0
+	}
+	return u
+}
+
+func generateNodes(length, offset, step int) []*Node {
+	nodes := []*Node{}
+	for i := 0; i < length; i++ {
+		nodes = append(nodes, NewNode(fmt.Sprintf("%x", offset+i*step), 2, 2, testHash))
+	}
+	return nodes
+}
+
+func joinAll(nodes []*Node) {
+	for _, n := range nodes[1:] {
+		n.Join(nodes[0])
+	}
+	for _, n := range nodes {
+		n.Refresh()
+	}
+}
@@ -0,0 +1,136 @@
+package login_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/masu-mi/gimmick.git/login"
+)
+
+func statelessKeys() *login.Keyring {
+	return &login.Keyring{Keys: []login.Key{{ID: 1, Secret: bytes.Repeat([]byte("k"), 32)}}}
+}
+
+func TestCallbackOfAnotherBrowser(t *testing.T) {
+	p := provider(t)
+	for _, stateless := range []bool{false, true} {
+		s := newService(p)
+		if stateless {
+			s.Keys = statelessKeys()
+		}
+		a := app(t, s)
+
+		// login CSRF: the attacker makes the victim complete the attacker's login
+		attacker, victim := browser(), browser()
+		cb := authorize(t, attacker, a)
+		follow(t, victim, a.URL+"/private")
+		if code := get(t, victim.Jar, cb); code != http.StatusBadRequest {
+			t.Errorf("stateless %v: callback in the victim's browser answered %d", stateless, code)
+		}
+		if code := get(t, nil, cb); code != http.StatusBadRequest {
+			t.Errorf("stateless %v: callback without cookies answered %d", stateless, code)
+		}
+		if to := follow(t, attacker, cb); to != "/private" {
+			t.Errorf("stateless %v: callback in the attacker's browser redirected to %s", stateless, to)
+		}
+	}
+}
+
+func TestStateIsSingleUse(t *testing.T) {
+	p := provider(t)
+	for _, stateless := range []bool{false, true} {
+		s := newService(p)
+		if stateless {
+			s.Keys = statelessKeys()
+		}
+		a := app(t, s)
+		c := browser()
+		cb := authorize(t, c, a)
+		follow(t, c, cb)
+		if code := get(t, c.Jar, cb); code != http.StatusBadRequest {
+			t.Errorf("stateless %v: replayed callback answered %d", stateless, code)
+		}
+	}
+
+	// the browser may not keep the cookie the callback re-sealed
+	s := newService(p)
+	s.Keys = statelessKeys()
+	a := app(t, s)
+	c := browser()
+	follow(t, c, a.URL+"/private")
+	auth := follow(t, c, a.URL+"/login")
+	original := copyJar(a, c.Jar)
+	follow(t, c, follow(t, c, auth))
+	// the provider grants another code to the same authorization request
+	if code := get(t, original, follow(t, c, auth)); code != http.StatusBadRequest {
+		t.Errorf("callback with the original sealed cookie answered %d", code)
+	}
+
+	// a state is used up even by a failed callback
+	s = newService(p)
+	a = app(t, s)
+	c = browser()
+	u, _ := url.Parse(authorize(t, c, a))
+	q := u.Query()
+	q.Set("code", "wrong")
+	if code := get(t, c.Jar, a.URL+"/login/callback?"+q.Encode()); code != http.StatusBadRequest {
+		t.Fatalf("callback with a wrong code answered %d", code)
+	}
+	if code := get(t, c.Jar, u.String()); code != http.StatusBadRequest {
+		t.Errorf("callback after a failed one answered %d", code)
+	}
+}
+
+func TestOpenRedirect(t *testing.T) {
+	p := provider(t)
+	s := newService(p)
+	s.RedirectAllowlist = []string{"/private", "/app/"}
+	a := app(t, s)
+	for from, want := range map[string]string{
+		"/private":                  "/private",
+		"/app/page?x=1":             "/app/page?x=1",
+		"/application":              "/",
+		"/admin":                    "/",
+		"//evil.example.com/x":      "/",
+		"https://evil.example.com/": "/",
+		"/\\evil.example.com":       "/",
+		"":                          "/",
+	} {
+		c := browser()
+		cb := authorize(t, c, a)
+		state, _ := url.Parse(cb)
+		ctx := context.Background()
+		pend, err := s.Store.GetPending(ctx, state.Query().Get("state"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pend.From = from
+		s.Store.PutPending(ctx, state.Query().Get("state"), pend)
+		if to := follow(t, c, cb); to != want {
+			t.Errorf("login from %q redirected to %q", from, to)
+		}
+	}
+}
+
+func TestCookieAttributes(t *testing.T) {
+	a := app(t, newService(provider(t)))
+	c := browser()
+	res, err := c.Get(a.URL + "/private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	cookies := res.Header["Set-Cookie"]
+	if len(cookies) == 0 {
+		t.Fatal("no cookie")
+	}
+	for _, v := range cookies {
+		if !strings.Contains(v, "HttpOnly") || !strings.Contains(v, "SameSite=Lax") {
+			t.Errorf("cookie %s", v)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+func (s *Service) stateTTL() time.Duration {
+	if s.StateTTL <= 0 {
+		return time.Minute
+	}
+	return s.StateTTL
+}
+
+// sealedLifetime is how long the user cookie of stateless mode is valid.
+// Session cookies of MaxAge 0 have no lifetime of their own, so MaxLifetime
+// or a day bounds them.
+func (s *Service) sealedLifetime() time.Duration {
+	switch {
+	case s.MaxLifetime > 0:
+		return s.MaxLifetime
+	case s.MaxAge > 0:
+		return time.Duration(s.MaxAge) * time.Second
+	}
+	return 24 * time.Hour
+}
+
+// expired reports whether the session of u is over at now.
+// Users stored before LoginAt was recorded don't expire.
+func (s *Service) expired(u User, now time.Time) bool {
+	if u.LoginAt.IsZero() {
+		return false
+	}
+	if s.MaxLifetime > 0 && !now.Before(u.LoginAt.Add(s.MaxLifetime)) {
+		return true
+	}
+	seen := u.SeenAt
+	if seen.IsZero() {
+		seen = u.LoginAt
+	}
+	return s.IdleTimeout > 0 && !now.Before(seen.Add(s.IdleTimeout))
+}
+
+// seen checks the session of stored u of id and records it's seen.
+// SeenAt is written once per a tenth of IdleTimeout at most, not every request.
+func (s *Service) seen(ctx context.Context, id string, u User) error {
+	now := time.Now()
+	if s.expired(u, now) {
+		s.Store.DeleteUser(ctx, id)
+		return fmt.Errorf("session of %s expired", id)
+	}
+	if s.IdleTimeout <= 0 || u.LoginAt.IsZero() || now.Sub(u.SeenAt) < s.IdleTimeout/10 {
+		return nil
+	}
+	u.SeenAt = now
+	return s.Store.PutUser(ctx, id, u)
+}
+
+// Sweep deletes pending logins older than StateTTL and users whose sessions
+// are over from Store, and returns the counts of those left.
+func (s *Service) Sweep(ctx context.Context) (Counts, error) {
+	now := time.Now()
+	n, err := s.Store.Sweep(ctx, now.Add(-s.stateTTL()), func(u User) bool {
+		return s.expired(u, now)
+	})
+	if err != nil {
+		atomic.AddInt64(&s.counts.sweepFailures, 1)
+		return n, err
+	}
+	atomic.AddInt64(&s.counts.sweeps, 1)
+	atomic.StoreInt64(&s.counts.pending, int64(n.Pending))
+	atomic.StoreInt64(&s.counts.users, int64(n.Users))
+	return n, nil
+}
+
+// RunSweeper calls Sweep every interval until ctx is done.
+// Non-positive interval means StateTTL.
+func (s *Service) RunSweeper(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = s.stateTTL()
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.Sweep(ctx)
+		}
+	}
+}
+
+// counters are what the last sweep found.
+type counters struct {
+	pending, users        int64
+	sweeps, sweepFailures int64
+}
+
+// Counts returns the counts found by the last Sweep.
+func (s *Service) Counts() Counts {
+	return Counts{
+		Pending: int(atomic.LoadInt64(&s.counts.pending)),
+		Users:   int(atomic.LoadInt64(&s.counts.users)),
+	}
+}
+
+// MetricsHandler exports the counts of the last Sweep in Prometheus text format.
+func (s *Service) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.WritePrometheus(w)
+	})
+}
+
+// WritePrometheus writes the counts of the last Sweep in Prometheus text format.
+func (s *Service) WritePrometheus(w io.Writer) error {
+	metrics := []struct {
+		name, typ, help string
+		value           *int64
+	}{
+		{"login_pending_sessions", "gauge", "Logins waiting for the callback.", &s.counts.pending},
+		{"login_active_users", "gauge", "Users whose sessions are not over.", &s.counts.users},
+		{"login_sweeps_total", "counter", "Sweeps of the store.", &s.counts.sweeps},
+		{"login_sweep_failures_total", "counter", "Sweeps which failed.", &s.counts.sweepFailures},
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{provider=%q} %d\n",
+			m.name, m.help, m.name, m.typ, m.name, s.Name, atomic.LoadInt64(m.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package login_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masu-mi/gimmick.git/login"
+)
+
+// backdate moves times of the user logged in with jar by d to the past.
+func backdate(t *testing.T, s *login.Service, a *httptest.Server, jar http.CookieJar, d time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	id := userOf(t, s, a, jar).Key
+	u, err := s.Store.GetUser(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.LoginAt, u.SeenAt = u.LoginAt.Add(-d), u.SeenAt.Add(-d)
+	s.Store.PutUser(ctx, id, u)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	p := provider(t)
+	for _, stateless := range []bool{false, true} {
+		s := newService(p)
+		s.IdleTimeout = time.Hour
+		if stateless {
+			s.Keys = &login.Keyring{Keys: []login.Key{{ID: 1, Secret: bytes.Repeat([]byte("k"), 32)}}}
+			s.Revocable = true
+		}
+		a := app(t, s)
+		jar := signIn(t, a)
+		backdate(t, s, a, jar, 50*time.Minute)
+		// a request keeps the session alive
+		if code := get(t, jar, a.URL+"/private"); code != http.StatusOK {
+			t.Fatalf("stateless %v: active user got %d", stateless, code)
+		}
+		backdate(t, s, a, jar, 50*time.Minute)
+		if code := get(t, jar, a.URL+"/private"); code != http.StatusOK {
+			t.Errorf("stateless %v: user seen 50 minutes ago got %d", stateless, code)
+		}
+		backdate(t, s, a, jar, 2*time.Hour)
+		if code := get(t, jar, a.URL+"/private"); code != http.StatusTemporaryRedirect {
+			t.Errorf("stateless %v: idle user got %d", stateless, code)
+		}
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	p := provider(t)
+	s := newService(p)
+	s.MaxLifetime = time.Hour
+	a := app(t, s)
+	jar := signIn(t, a)
+	backdate(t, s, a, jar, 30*time.Minute)
+	if code := get(t, jar, a.URL+"/private"); code != http.StatusOK {
+		t.Fatalf("user of 30 minutes got %d", code)
+	}
+	backdate(t, s, a, jar, 31*time.Minute)
+	if code := get(t, jar, a.URL+"/private"); code != http.StatusTemporaryRedirect {
+		t.Errorf("user of 61 minutes got %d", code)
+	}
+}
+
+func TestStateTTL(t *testing.T) {
+	s := newService(provider(t))
+	s.StateTTL = time.Hour
+	a := app(t, s)
+	ctx := context.Background()
+	for state, age := range map[string]time.Duration{"recent": 30 * time.Minute, "old": 2 * time.Hour} {
+		s.Store.PutPending(ctx, state, login.Pending{StartAt: time.Now().Add(-age), From: "/", Verifier: "v"})
+		req, _ := http.NewRequest(http.MethodGet, a.URL+"/login/callback?"+url.Values{"state": {state}, "code": {"c"}}.Encode(), nil)
+		req.AddCookie(&http.Cookie{Name: s.SessionCookie, Value: state})
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if timedOut := strings.Contains(string(b), "time out"); timedOut != (state == "old") {
+			t.Errorf("state of %v ago: %q", age, b)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	s := newService(provider(t))
+	s.IdleTimeout = time.Hour
+	ctx := context.Background()
+	now := time.Now()
+	s.Store.PutPending(ctx, "old", login.Pending{StartAt: now.Add(-time.Hour)})
+	s.Store.PutPending(ctx, "new", login.Pending{StartAt: now})
+	s.Store.PutUser(ctx, "idle", login.User{Session: "s1", LoginAt: now.Add(-2 * time.Hour)})
+	s.Store.PutUser(ctx, "active", login.User{Session: "s2", LoginAt: now.Add(-2 * time.Hour), SeenAt: now})
+
+	sweeping, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		s.RunSweeper(sweeping, time.Millisecond)
+		close(done)
+	}()
+	for i := 0; s.Counts() != (login.Counts{Pending: 1, Users: 1}); i++ {
+		if i == 1000 {
+			t.Fatalf("counts %+v", s.Counts())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	<-done
+	if _, err := s.Store.GetUser(ctx, "idle"); err != login.ErrNotFound {
+		t.Errorf("idle user stays: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, m := range []string{`login_pending_sessions{provider=""} 1`, `login_active_users{provider=""} 1`} {
+		if !strings.Contains(rec.Body.String(), m) {
+			t.Errorf("no %s in\n%s", m, rec.Body)
+		}
+	}
+	// interval 0 doesn't panic
+	s.RunSweeper(sweeping, 0)
+}
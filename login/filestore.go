@@ -0,0 +1,256 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pendingBucket = []byte("pending")
+	usersBucket   = []byte("users")
+	// loginsBucket indexes users by User.ID; its keys are loginKey of them.
+	loginsBucket = []byte("logins")
+)
+
+// FileStore is a Store in a single bbolt file.
+// Only one process can open the file at a time.
+type FileStore struct {
+	db *bolt.DB
+}
+
+var _ Store = (*FileStore)(nil)
+
+// OpenFileStore opens the store at path, creating it.
+func OpenFileStore(path string) (*FileStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{pendingBucket, usersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(loginsBucket) != nil {
+			return nil
+		}
+		// files of older versions have users but no index of them
+		if _, err := tx.CreateBucket(loginsBucket); err != nil {
+			return err
+		}
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			return index(tx, k, v)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &FileStore{db: db}, nil
+}
+
+// Close closes the file.
+func (f *FileStore) Close() error {
+	return f.db.Close()
+}
+
+func (f *FileStore) put(bucket []byte, k string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return f.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(k), b)
+	})
+}
+
+func (f *FileStore) get(bucket []byte, k string, v interface{}) error {
+	return f.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket).Get([]byte(k))
+		if b == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(b, v)
+	})
+}
+
+func (f *FileStore) delete(bucket []byte, k string) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(k))
+	})
+}
+
+// PutPending stores p by state.
+func (f *FileStore) PutPending(_ context.Context, state string, p Pending) error {
+	return f.put(pendingBucket, state, p)
+}
+
+// GetPending returns the login of state.
+func (f *FileStore) GetPending(_ context.Context, state string) (Pending, error) {
+	var p Pending
+	err := f.get(pendingBucket, state, &p)
+	return p, err
+}
+
+// DeletePending deletes the login of state.
+func (f *FileStore) DeletePending(_ context.Context, state string) error {
+	return f.delete(pendingBucket, state)
+}
+
+// loginKey returns the key of the login k of the user of User.ID id in
+// loginsBucket, or nil for users without ID.
+func loginKey(id string, k []byte) []byte {
+	if id == "" {
+		return nil
+	}
+	return append([]byte(id+"\x00"), k...)
+}
+
+// indexKey returns loginKey of the user v stored by k.
+func indexKey(k, v []byte) ([]byte, error) {
+	var u User
+	if err := json.Unmarshal(v, &u); err != nil {
+		return nil, err
+	}
+	return loginKey(u.ID, k), nil
+}
+
+// index adds the user v stored by k to loginsBucket.
+func index(tx *bolt.Tx, k, v []byte) error {
+	lk, err := indexKey(k, v)
+	if err != nil || lk == nil {
+		return err
+	}
+	return tx.Bucket(loginsBucket).Put(lk, []byte{})
+}
+
+// unindex deletes the user stored by k from loginsBucket.
+func unindex(tx *bolt.Tx, k []byte) error {
+	v := tx.Bucket(usersBucket).Get(k)
+	if v == nil {
+		return nil
+	}
+	lk, err := indexKey(k, v)
+	if err != nil || lk == nil {
+		return err
+	}
+	return tx.Bucket(loginsBucket).Delete(lk)
+}
+
+// PutUser stores u by id.
+func (f *FileStore) PutUser(_ context.Context, id string, u User) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return f.db.Update(func(tx *bolt.Tx) error {
+		if err := unindex(tx, []byte(id)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(usersBucket).Put([]byte(id), b); err != nil {
+			return err
+		}
+		return index(tx, []byte(id), b)
+	})
+}
+
+// GetUser returns the user of id.
+func (f *FileStore) GetUser(_ context.Context, id string) (User, error) {
+	var u User
+	err := f.get(usersBucket, id, &u)
+	return u, err
+}
+
+// DeleteUser deletes the user of id.
+func (f *FileStore) DeleteUser(_ context.Context, id string) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		if err := unindex(tx, []byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(usersBucket).Delete([]byte(id))
+	})
+}
+
+// DeleteUserLogins deletes the users of User.ID id.
+func (f *FileStore) DeleteUserLogins(_ context.Context, id string) error {
+	prefix := loginKey(id, nil)
+	if prefix == nil {
+		return nil
+	}
+	return f.db.Update(func(tx *bolt.Tx) error {
+		logins, users := tx.Bucket(loginsBucket), tx.Bucket(usersBucket)
+		// deleting with a cursor skips keys, so keys are collected first
+		var keys [][]byte
+		c := logins.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			if err := logins.Delete(k); err != nil {
+				return err
+			}
+			if err := users.Delete(k[len(prefix):]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Sweep deletes expired pending logins and users.
+func (f *FileStore) Sweep(_ context.Context, pendingBefore time.Time, expired func(User) bool) (Counts, error) {
+	var n Counts
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		n.Pending, err = sweepBucket(tx.Bucket(pendingBucket), func(_, b []byte) (bool, error) {
+			var p Pending
+			err := json.Unmarshal(b, &p)
+			return err == nil && p.StartAt.Before(pendingBefore), err
+		})
+		if err != nil {
+			return err
+		}
+		n.Users, err = sweepBucket(tx.Bucket(usersBucket), func(k, b []byte) (bool, error) {
+			var u User
+			if err := json.Unmarshal(b, &u); err != nil || !expired(u) {
+				return false, err
+			}
+			return true, unindex(tx, k)
+		})
+		return err
+	})
+	return n, err
+}
+
+// sweepBucket deletes values of b expired reports and returns the number left.
+func sweepBucket(b *bolt.Bucket, expired func(k, v []byte) (bool, error)) (int, error) {
+	// deleting with a cursor skips keys, so keys are collected first
+	var keys [][]byte
+	left := 0
+	err := b.ForEach(func(k, v []byte) error {
+		x, err := expired(k, v)
+		if err != nil {
+			return err
+		}
+		if x {
+			keys = append(keys, k)
+		} else {
+			left++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return left, nil
+}
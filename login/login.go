@@ -3,9 +3,9 @@ package login
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
-	"sync"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/satori/go.uuid"
@@ -13,24 +13,67 @@ import (
 )
 
 type (
-	session struct {
-		startAt time.Time
-		from    string
-	}
 	// User is user
 	User struct {
 		*oauth2.Token
-		session string
+		// Session is the state of the login which registered the user.
+		Session string
+		// ID identifies the user. With OIDC it is the subject the provider
+		// identifies the user by, otherwise it is random for each login.
+		// It is prefixed by the name of the provider and a colon when the
+		// service has a name.
+		ID string
+		// Key is the key of the user in Store, a random handle of the login
+		// which the user cookie carries. Each login of a user has its own.
+		// Users registered by RegisterUser have none; their ID is the key.
+		Key string
+		// Provider is the name of the service which authenticated the user.
+		Provider string
+		// Claims are the claims of the ID token with OIDC.
+		// The user cookie of stateless mode keeps only issuer, subject,
+		// expiry, email and name; the others are found with Revocable,
+		// which reads the user from Store.
+		Claims *Claims
+		// IDToken is the raw ID token with OIDC, the hint of logout at the provider.
+		IDToken string
+		// LoginAt is when the user logged in and SeenAt is when the user
+		// was found by GetUser last, as far as IdleTimeout needs.
+		LoginAt time.Time
+		SeenAt  time.Time
 	}
 	// Service is login service
 	Service struct {
+		// Name names the identity provider of the service among Providers.
+		Name   string
 		Config *oauth2.Config
+		// Store keeps pending logins and users.
+		Store Store
+		// Keys makes cookies stateless when it is set: the user and the
+		// pending login are sealed into cookies, so GetUser works without
+		// Store. Tokens are still put to Store and referenced by User.Key,
+		// and states of completed logins are put there until they expire.
+		Keys *Keyring
+		// OIDC verifies ID tokens of logins when it is set.
+		// Config.Endpoint should be OIDC.Endpoint() and Config.Scopes should include "openid".
+		OIDC *OIDCProvider
+		// Revocable makes GetUser of stateless mode check that the user is
+		// still in Store, so logout and RevokeUser take effect before the
+		// cookies expire.
+		Revocable bool
+		// PostLogoutRedirectURL is where logout ends. Empty means "/".
+		PostLogoutRedirectURL string
 
-		mSess    sync.RWMutex
-		sessions map[string]*session
+		// StateTTL is how long a login may wait for the callback. Zero means 1 minute.
+		StateTTL time.Duration
+		// IdleTimeout ends sessions of users not seen for it; zero disables it.
+		// Stateless mode tracks idle users only when Revocable is set.
+		IdleTimeout time.Duration
+		// MaxLifetime ends sessions that long after the login; zero disables it.
+		MaxLifetime time.Duration
 
-		mUser sync.RWMutex
-		users map[string]User
+		// RedirectAllowlist lists path prefixes logins may return to.
+		// Empty allows any path of this origin; other targets end at "/".
+		RedirectAllowlist []string
 
 		UserCookie    string
 		SessionCookie string
@@ -39,130 +82,385 @@ type (
 		Domain string
 		Path   string
 		MaxAge int
+		// SameSite of the cookies. It must not be Strict, or the callback
+		// from the provider doesn't get the session cookie.
+		SameSite http.SameSite
+
+		counts counters
 	}
 )
 
 // NewService creates login service
 func NewService(a *oauth2.Config) *Service {
 	return &Service{
-		Config:   a,
-		mSess:    sync.RWMutex{},
-		sessions: map[string]*session{},
-		mUser:    sync.RWMutex{},
-		users:    map[string]User{},
+		Config: a,
+		Store:  NewMemoryStore(),
 
 		UserCookie:    "_I",
 		SessionCookie: "_s",
 
-		Secure: false,
-		Domain: "localhost",
-		Path:   "/",
-		MaxAge: 600,
+		Secure:   false,
+		Domain:   "localhost",
+		Path:     "/",
+		MaxAge:   600,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+type (
+	// sealedUser is the user cookie of stateless mode.
+	sealedUser struct {
+		ID       string  `json:"id"`
+		Key      string  `json:"k,omitempty"`
+		Provider string  `json:"p,omitempty"`
+		Session  string  `json:"s"`
+		LoginAt  int64   `json:"at,omitempty"`
+		Expires  int64   `json:"exp"`
+		Claims   *Claims `json:"c,omitempty"`
+	}
+	// sealedPending is the session cookie of stateless mode.
+	sealedPending struct {
+		State   string `json:"st"`
+		StartAt int64  `json:"at"`
+		From    string `json:"from"`
+		// Verifier is sealed as well; the session cookie is the only place it is kept.
+		Verifier string `json:"v"`
+		Nonce    string `json:"n,omitempty"`
+		// Used is set by the callback, so the cookie can't complete another login.
+		Used bool `json:"u,omitempty"`
+	}
+)
+
+// maxCookieSize is the size of cookies every browser keeps, name and attributes included.
+const maxCookieSize = 4096
+
+// sealedClaims returns the claims of c the user cookie keeps.
+// Other claims, e.g. groups, may be too large for a cookie.
+func sealedClaims(c *Claims) *Claims {
+	if c == nil {
+		return nil
+	}
+	return &Claims{
+		Issuer: c.Issuer, Subject: c.Subject, Expiry: c.Expiry,
+		Email: c.Email, EmailVerified: c.EmailVerified, Name: c.Name,
 	}
 }
 
-// GetUser gets user infomation
+// GetUser gets user infomation.
+// In stateless mode the user has no Token; Token finds it.
 func (s *Service) GetUser(r *http.Request) (*User, error) {
+	if s.Keys != nil {
+		return s.openUser(r)
+	}
 	if c, err := r.Cookie(s.UserCookie); err != nil {
 		return nil, err
-	} else if u, ok := s.users[c.Value]; !ok {
+	} else if u, err := s.Store.GetUser(r.Context(), c.Value); err == ErrNotFound {
 		return nil, errors.New("no user " + c.Value)
+	} else if err != nil {
+		return nil, err
 	} else if sess, err := r.Cookie(s.SessionCookie); err != nil {
 		return nil, err
-	} else if sess.Value != u.session {
+	} else if sess.Value != u.Session {
 		return nil, errors.New("sesssion timeouot")
+	} else if err := s.seen(r.Context(), c.Value, u); err != nil {
+		return nil, err
 	} else {
+		if u.ID == "" {
+			u.ID = c.Value
+		}
+		u.Key = c.Value
 		return &u, nil
 	}
 }
 
-// RegisterUser registers user infomation with session
+func (s *Service) openUser(r *http.Request) (*User, error) {
+	c, err := r.Cookie(s.UserCookie)
+	if err != nil {
+		return nil, err
+	}
+	var su sealedUser
+	if err := s.Keys.Open(s.UserCookie, c.Value, &su); err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(time.Unix(su.Expires, 0)) {
+		return nil, errors.New("user cookie expired")
+	}
+	state, _, err := s.openPending(r)
+	if err != nil {
+		return nil, err
+	}
+	if state != su.Session {
+		return nil, errors.New("sesssion timeouot")
+	}
+	// the cookie tells nothing about idleness; Revocable looks into Store for it
+	u := &User{ID: su.ID, Key: su.Key, Provider: su.Provider, Session: su.Session, Claims: su.Claims, SeenAt: time.Now()}
+	if su.LoginAt != 0 {
+		u.LoginAt = time.Unix(su.LoginAt, 0)
+	}
+	if s.expired(*u, time.Now()) {
+		return nil, errors.New("session expired")
+	}
+	if s.Revocable {
+		if stored, err := s.Store.GetUser(r.Context(), u.storeKey()); err == ErrNotFound {
+			return nil, errors.New("user is revoked")
+		} else if err != nil {
+			return nil, err
+		} else if err := s.seen(r.Context(), u.storeKey(), stored); err != nil {
+			return nil, err
+		} else if stored.Claims != nil {
+			u.Claims = stored.Claims
+		}
+	}
+	return u, nil
+}
+
+// storeKey returns the key of u in Store.
+func (u *User) storeKey() string {
+	if u.Key != "" {
+		return u.Key
+	}
+	return u.ID
+}
+
+// Token returns the token of u, looking it up in Store by u.Key when u has none.
+func (s *Service) Token(ctx context.Context, u *User) (*oauth2.Token, error) {
+	if u.Token != nil {
+		return u.Token, nil
+	}
+	stored, err := s.Store.GetUser(ctx, u.storeKey())
+	if err != nil {
+		return nil, err
+	}
+	return stored.Token, nil
+}
+
+// RegisterUser registers user infomation with session.
+// It ignores errors of Store; RegisterUserContext returns them.
 func (s *Service) RegisterUser(session, id string, t *oauth2.Token) {
-	s.mSess.Lock()
-	delete(s.sessions, session)
-	s.mSess.Unlock()
+	s.RegisterUserContext(context.Background(), session, id, t)
+}
 
-	s.mUser.Lock()
-	s.users[id] = User{session: session, Token: t}
-	s.mUser.Unlock()
+// RegisterUserContext registers user infomation with session in Store.
+func (s *Service) RegisterUserContext(ctx context.Context, session, id string, t *oauth2.Token) error {
+	now := time.Now()
+	return s.registerUser(ctx, id, User{Session: session, Token: t, LoginAt: now, SeenAt: now})
 }
 
-func (s *Service) registerSession(path string) string {
+func (s *Service) registerUser(ctx context.Context, id string, u User) error {
+	// stateless mode keeps the consumed state till it expires
+	if s.Keys == nil {
+		if err := s.Store.DeletePending(ctx, u.Session); err != nil {
+			return err
+		}
+	}
+	return s.Store.PutUser(ctx, id, u)
+}
+
+// registerSession starts a login from path and returns the value of the session cookie.
+func (s *Service) registerSession(ctx context.Context, path string) (string, error) {
 	state := newState()
-	s.mSess.Lock()
-	s.sessions[state] = &session{
-		startAt: time.Now(),
-		from:    path,
-	}
-	s.mSess.Unlock()
-	return state
-}
-func (s *Service) getSession(state string) (*session, error) {
-	s.mSess.RLock()
-	sess, ok := s.sessions[state]
-	s.mSess.RUnlock()
-	if !ok {
+	p := Pending{
+		StartAt:  time.Now(),
+		From:     path,
+		Verifier: oauth2.GenerateVerifier(),
+		// any provider of Providers may complete the login, so the nonce
+		// is made even if s doesn't use OIDC
+		Nonce: newState(),
+	}
+	if s.Keys != nil {
+		return s.Keys.Seal(s.SessionCookie, sealedPending{
+			State: state, StartAt: p.StartAt.Unix(), From: p.From,
+			Verifier: p.Verifier, Nonce: p.Nonce,
+		})
+	}
+	err := s.Store.PutPending(ctx, state, p)
+	return state, err
+}
+func (s *Service) getSession(ctx context.Context, state string) (*Pending, error) {
+	sess, err := s.Store.GetPending(ctx, state)
+	if err == ErrNotFound {
 		return nil, errors.New("no session in sessions")
-	} else if time.Now().After(sess.startAt.Add(1 * time.Minute)) {
-		return nil, errors.New("time out")
+	} else if err != nil {
+		return nil, err
+	}
+	return &sess, s.checkPending(sess)
+}
+
+func (s *Service) checkPending(p Pending) error {
+	if time.Now().After(p.StartAt.Add(s.stateTTL())) {
+		return errors.New("time out")
+	}
+	return nil
+}
+
+// consume records state of the sealed login p as used in Store, so the
+// sealed session cookie can't complete another login even if the browser
+// didn't keep the cookie of Used; Sweep deletes it after StateTTL.
+// Two callbacks racing with one cookie may both pass.
+func (s *Service) consume(ctx context.Context, state string, p *Pending) error {
+	if _, err := s.Store.GetPending(ctx, state); err == nil {
+		return errors.New("state is used")
+	} else if err != ErrNotFound {
+		return err
+	}
+	return s.Store.PutPending(ctx, state, Pending{StartAt: p.StartAt})
+}
+
+// openPending returns the state and the login of the sealed session cookie.
+func (s *Service) openPending(r *http.Request) (string, *Pending, error) {
+	c, err := r.Cookie(s.SessionCookie)
+	if err != nil {
+		return "", nil, err
+	}
+	var sp sealedPending
+	if err := s.Keys.Open(s.SessionCookie, c.Value, &sp); err != nil {
+		return "", nil, err
+	}
+	return sp.State, &Pending{StartAt: time.Unix(sp.StartAt, 0), From: sp.From, Verifier: sp.Verifier, Nonce: sp.Nonce, used: sp.Used}, nil
+}
+
+// pendingOf returns the state and the login r is in.
+func (s *Service) pendingOf(r *http.Request) (string, *Pending, error) {
+	if s.Keys != nil {
+		state, p, err := s.openPending(r)
+		if err != nil {
+			return "", nil, err
+		}
+		return state, p, s.checkPending(*p)
+	}
+	c, err := r.Cookie(s.SessionCookie)
+	if err != nil {
+		return "", nil, err
 	}
-	return sess, nil
+	p, err := s.getSession(r.Context(), c.Value)
+	return c.Value, p, err
 }
 
 // AddService add login service to mux
 func AddService(m *http.ServeMux, path string, s *Service) func(http.HandlerFunc) http.HandlerFunc {
 	m.HandleFunc(path, s.startLoginHandler)
 	m.HandleFunc(path+"/callback", s.callbackHandler)
+	m.HandleFunc(path+"/logout", s.logoutHandler)
 	return s.authenticator(path)
 }
 
 func (s *Service) authenticator(prefix string) func(http.HandlerFunc) http.HandlerFunc {
+	m := s.Middleware(prefix)
 	return func(h http.HandlerFunc) http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			if _, err := s.GetUser(r); err != nil {
-				s.setCookie(w,
-					s.SessionCookie, s.registerSession(r.URL.Path),
-				)
-				http.Redirect(w, r, prefix, http.StatusTemporaryRedirect)
-				return
-			}
-			h(w, r)
-		}
+		return m(h).ServeHTTP
 	}
 }
 func (s *Service) startLoginHandler(w http.ResponseWriter, r *http.Request) {
-	if c, err := r.Cookie(s.SessionCookie); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-	} else if _, err := s.getSession(c.Value); err != nil {
+	if state, p, err := s.pendingOf(r); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	} else {
-		http.Redirect(w, r, s.Config.AuthCodeURL(c.Value), http.StatusTemporaryRedirect)
+		opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(p.Verifier)}
+		if s.OIDC != nil && p.Nonce != "" {
+			opts = append(opts, oauth2.SetAuthURLParam("nonce", p.Nonce))
+		}
+		http.Redirect(w, r, s.Config.AuthCodeURL(state, opts...), http.StatusTemporaryRedirect)
 	}
 }
 func (s *Service) callbackHandler(w http.ResponseWriter, r *http.Request) {
 	state := r.URL.Query().Get("state")
-	sess, err := s.getSession(state)
+	var sess *Pending
+	var err error
+	// the state must be of the login this browser started, and only once
+	if s.Keys != nil {
+		var sealed string
+		if sealed, sess, err = s.pendingOf(r); err == nil && sealed != state {
+			err = errors.New("state doesn't match the session")
+		} else if err == nil && sess.used {
+			err = errors.New("state is used")
+		} else if err == nil {
+			err = s.consume(r.Context(), state, sess)
+		}
+	} else if c, cerr := r.Cookie(s.SessionCookie); cerr != nil || c.Value != state {
+		err = errors.New("state doesn't match the session")
+	} else if sess, err = s.getSession(r.Context(), state); err == nil {
+		err = s.Store.DeletePending(r.Context(), state)
+	}
+	if err == nil && sess.Verifier == "" {
+		err = errors.New("no code verifier in the session")
+	}
 	if err != nil {
-		fmt.Println(err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 	code := r.URL.Query().Get("code")
-	token, err := s.Config.Exchange(context.TODO(), code)
+	token, err := s.Config.Exchange(r.Context(), code, oauth2.VerifierOption(sess.Verifier))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	id := newState()
-	s.RegisterUser(state, id, token)
-	s.setCookie(w, s.UserCookie, id)
+	now := time.Now()
+	// the subject is known to anyone; the cookie carries a random key instead
+	key := newState()
+	id, u := key, User{Key: key, Session: state, Token: token, Provider: s.Name, LoginAt: now, SeenAt: now}
+	if s.OIDC != nil {
+		raw, _ := token.Extra("id_token").(string)
+		if u.Claims, err = s.OIDC.Verify(r.Context(), raw, s.Config.ClientID, sess.Nonce); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id, u.IDToken = u.Claims.Subject, raw
+	}
+	if s.Name != "" {
+		id = s.Name + ":" + id
+	}
+	u.ID = id
+	if err := s.registerUser(r.Context(), key, u); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	v := key
+	if s.Keys != nil {
+		expires := now.Add(s.sealedLifetime())
+		if v, err = s.Keys.Seal(s.UserCookie, sealedUser{
+			ID: id, Key: key, Provider: s.Name, Session: state,
+			LoginAt: now.Unix(), Expires: expires.Unix(), Claims: sealedClaims(u.Claims),
+		}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(s.UserCookie)+len(v) > maxCookieSize-256 {
+			// browsers drop it silently and the login would loop
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("user cookie is too large"))
+			return
+		}
+	}
+	s.setCookie(w, s.UserCookie, v)
+	if s.Keys != nil {
+		if v, err = s.Keys.Seal(s.SessionCookie, sealedPending{
+			State: state, StartAt: sess.StartAt.Unix(), From: sess.From, Used: true,
+		}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.setCookie(w, s.SessionCookie, v)
+	}
+	http.Redirect(w, r, s.redirectPath(sess.From), http.StatusTemporaryRedirect)
+}
 
-	path := sess.from
-	if path == "" {
-		path = "/"
+// redirectPath returns from if it is a path of this origin which
+// RedirectAllowlist allows, or "/".
+func (s *Service) redirectPath(from string) string {
+	u, err := url.Parse(from)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil ||
+		!strings.HasPrefix(from, "/") || strings.HasPrefix(from, "//") || strings.ContainsAny(from, "\\\r\n") {
+		return "/"
+	}
+	if len(s.RedirectAllowlist) == 0 {
+		return from
 	}
-	http.Redirect(w, r, path, http.StatusTemporaryRedirect)
+	for _, p := range s.RedirectAllowlist {
+		if u.Path == p || strings.HasPrefix(u.Path, strings.TrimSuffix(p, "/")+"/") {
+			return from
+		}
+	}
+	return "/"
 }
 func (s *Service) setCookie(w http.ResponseWriter, k, v string) {
 	http.SetCookie(w, &http.Cookie{
@@ -171,6 +469,9 @@ func (s *Service) setCookie(w http.ResponseWriter, k, v string) {
 		Domain: s.Domain,
 		Path:   s.Path,
 		MaxAge: s.MaxAge,
+
+		HttpOnly: true,
+		SameSite: s.SameSite,
 	})
 }
 func newState() string {
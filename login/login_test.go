@@ -0,0 +1,317 @@
+package login_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masu-mi/gimmick.git/login"
+	"golang.org/x/oauth2"
+)
+
+// fakeProvider is an OpenID Connect provider which grants any code to
+// user-1, requires PKCE and refreshes refresh token "rt". Refresh token
+// "unavailable" gets 503.
+type fakeProvider struct {
+	*httptest.Server
+
+	m          sync.Mutex
+	codes      int
+	challenges map[string]string
+	nonces     map[string]string
+	// keys are published in JWKS; ID tokens are signed by the last one.
+	keys []*rsa.PrivateKey
+	// groups of user-1; nil means "dev".
+	groups []string
+}
+
+func provider(t *testing.T) *fakeProvider {
+	p := &fakeProvider{challenges: map[string]string{}, nonces: map[string]string{}}
+	p.rotate(t)
+	m := http.NewServeMux()
+	m.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/auth",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+			"end_session_endpoint":   p.URL + "/logout",
+		})
+	})
+	m.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.m.Lock()
+		defer p.m.Unlock()
+		var keys []map[string]string
+		for i, k := range p.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA", "use": "sig", "alg": "RS256", "kid": fmt.Sprint("k", i),
+				"n": base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	m.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "PKCE is required", http.StatusBadRequest)
+			return
+		}
+		p.m.Lock()
+		code := fmt.Sprint("c", p.codes)
+		p.codes++
+		p.challenges[code] = q.Get("code_challenge")
+		p.nonces[code] = q.Get("nonce")
+		p.m.Unlock()
+		cb := url.Values{"code": {code}, "state": {q.Get("state")}}
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+cb.Encode(), http.StatusFound)
+	})
+	m.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		code := r.FormValue("code")
+		idToken := ""
+		switch r.FormValue("grant_type") {
+		case "refresh_token":
+			if r.FormValue("refresh_token") == "unavailable" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.FormValue("refresh_token") != "rt" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			code = "refreshed"
+		default:
+			p.m.Lock()
+			challenge, ok := p.challenges[code]
+			nonce := p.nonces[code]
+			delete(p.challenges, code)
+			p.m.Unlock()
+			if !ok || challenge != oauth2.S256ChallengeFromVerifier(r.FormValue("code_verifier")) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			idToken = p.sign(nil, p.claims(nonce))
+		}
+		w.Write([]byte(`{"access_token":"at-` + code + `","token_type":"Bearer","refresh_token":"rt","expires_in":3600,"id_token":"` + idToken + `"}`))
+	})
+	p.Server = httptest.NewServer(m)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// rotate adds a new signing key.
+func (p *fakeProvider) rotate(t *testing.T) {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.m.Lock()
+	p.keys = append(p.keys, k)
+	p.m.Unlock()
+}
+
+// claims returns claims of an ID token of user-1.
+func (p *fakeProvider) claims(nonce string) map[string]interface{} {
+	groups := p.groups
+	if groups == nil {
+		groups = []string{"dev"}
+	}
+	return map[string]interface{}{
+		"iss": p.URL, "sub": "user-1", "aud": "client",
+		"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+		"nonce": nonce, "email": "user-1@example.com", "groups": groups,
+	}
+}
+
+// sign returns an ID token of claims signed by the current key.
+// header overrides fields of the JWT header.
+func (p *fakeProvider) sign(header, claims map[string]interface{}) string {
+	p.m.Lock()
+	i := len(p.keys) - 1
+	k := p.keys[i]
+	p.m.Unlock()
+	h := map[string]interface{}{"alg": "RS256", "typ": "JWT", "kid": fmt.Sprint("k", i)}
+	for f, v := range header {
+		h[f] = v
+	}
+	hb, _ := json.Marshal(h)
+	cb, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	d := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, d[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// app serves s at /login and a page which needs login at /private.
+func app(t *testing.T, s *login.Service) *httptest.Server {
+	m := http.NewServeMux()
+	auth := login.AddService(m, "/login", s)
+	m.HandleFunc("/private", auth(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("private"))
+	}))
+	a := httptest.NewServer(m)
+	t.Cleanup(a.Close)
+	s.Config.RedirectURL = a.URL + "/login/callback"
+	return a
+}
+
+func newService(p *fakeProvider) *login.Service {
+	s := login.NewService(&oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{AuthURL: p.URL + "/auth", TokenURL: p.URL + "/token"},
+	})
+	s.Domain = ""
+	return s
+}
+
+// browser returns a client which keeps cookies and doesn't follow redirects.
+func browser() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Jar: jar, CheckRedirect: func(r *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+// follow requests u by c and returns where it redirects to.
+func follow(t *testing.T, c *http.Client, u string) string {
+	t.Helper()
+	res, err := c.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 3 {
+		t.Fatalf("%s answered %d", u, res.StatusCode)
+	}
+	return res.Header.Get("Location")
+}
+
+// authorize starts a login to a and returns the callback URL the provider redirects c to.
+func authorize(t *testing.T, c *http.Client, a *httptest.Server) string {
+	t.Helper()
+	follow(t, c, a.URL+"/private")
+	return follow(t, c, follow(t, c, a.URL+"/login"))
+}
+
+// signIn logs in to a through the provider and returns the cookie jar.
+func signIn(t *testing.T, a *httptest.Server) http.CookieJar {
+	t.Helper()
+	c := browser()
+	if to := follow(t, c, authorize(t, c, a)); to != "/private" {
+		t.Fatalf("callback redirected to %s", to)
+	}
+	return c.Jar
+}
+
+func get(t *testing.T, jar http.CookieJar, u string) int {
+	t.Helper()
+	c := &http.Client{Jar: jar, CheckRedirect: func(r *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := c.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestLogin(t *testing.T) {
+	a := app(t, newService(provider(t)))
+	jar := signIn(t, a)
+	if code := get(t, jar, a.URL+"/private"); code != http.StatusOK {
+		t.Errorf("logged-in user got %d", code)
+	}
+	if code := get(t, nil, a.URL+"/private"); code != http.StatusTemporaryRedirect {
+		t.Errorf("anonymous user got %d", code)
+	}
+}
+
+func TestRegisterUser(t *testing.T) {
+	s := login.NewService(&oauth2.Config{})
+	ctx := context.Background()
+	s.Store.PutPending(ctx, "state", login.Pending{StartAt: time.Now()})
+	s.RegisterUser("state", "u1", &oauth2.Token{AccessToken: "at"})
+	if u, err := s.Store.GetUser(ctx, "u1"); err != nil || u.Session != "state" || u.AccessToken != "at" || u.LoginAt.IsZero() {
+		t.Errorf("registered user %+v: %v", u, err)
+	}
+	if _, err := s.Store.GetPending(ctx, "state"); err != login.ErrNotFound {
+		t.Errorf("pending login of a registered user: %v", err)
+	}
+}
+
+func TestStatelessCookies(t *testing.T) {
+	p := provider(t)
+	keys := &login.Keyring{Keys: []login.Key{{ID: 1, Secret: bytes.Repeat([]byte("k"), 32)}}}
+	s1, s2 := newService(p), newService(p)
+	s1.Keys, s2.Keys = keys, keys
+	a1, a2 := app(t, s1), app(t, s2)
+
+	jar := signIn(t, a1)
+	// cookies of a1 go to a2 on the same host, which shares no store with a1
+	if code := get(t, jar, a2.URL+"/private"); code != http.StatusOK {
+		t.Errorf("replica answered %d", code)
+	}
+
+	s3 := newService(p)
+	s3.Keys = &login.Keyring{Keys: []login.Key{{ID: 2, Secret: bytes.Repeat([]byte("x"), 32)}}}
+	a3 := app(t, s3)
+	if code := get(t, jar, a3.URL+"/private"); code != http.StatusTemporaryRedirect {
+		t.Errorf("service with other keys answered %d", code)
+	}
+}
+
+func TestStatelessSessionCookies(t *testing.T) {
+	s := newService(provider(t))
+	s.Keys = statelessKeys()
+	s.MaxAge = 0
+	a := app(t, s)
+	if code := get(t, signIn(t, a), a.URL+"/private"); code != http.StatusOK {
+		t.Errorf("user of session cookies got %d", code)
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	p := provider(t)
+	s := newService(p)
+	a := app(t, s)
+
+	// an attacker injects the code of its own login into the victim's callback
+	victim, attacker := browser(), browser()
+	authorize(t, victim, a)
+	stolen, _ := url.Parse(authorize(t, attacker, a))
+	res, _ := victim.Get(a.URL + "/login")
+	res.Body.Close()
+	own, _ := url.Parse(res.Header.Get("Location"))
+	if own.Query().Get("code_challenge") == "" || own.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("no code challenge in %s", own)
+	}
+	q := url.Values{"state": {own.Query().Get("state")}, "code": {stolen.Query().Get("code")}}
+	if code := get(t, victim.Jar, a.URL+"/login/callback?"+q.Encode()); code != http.StatusBadRequest {
+		t.Errorf("callback with a code of another verifier answered %d", code)
+	}
+
+	// a pending login without verifier, e.g. stored before PKCE
+	s.Store.PutPending(context.Background(), "old", login.Pending{StartAt: time.Now(), From: "/"})
+	q = url.Values{"state": {"old"}, "code": {"c0"}}
+	if code := get(t, nil, a.URL+"/login/callback?"+q.Encode()); code != http.StatusBadRequest {
+		t.Errorf("callback without verifier answered %d", code)
+	}
+}
@@ -0,0 +1,82 @@
+package login
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// RevokeUser deletes every login of the user of id from Store, ending all
+// the sessions of the user. With OIDC id is the subject (prefixed by the
+// provider name), so this is the global sign-out of the subject. Stateless
+// cookies are revoked only when Revocable is set.
+func (s *Service) RevokeUser(ctx context.Context, id string) error {
+	// logins are kept by random keys
+	if err := s.Store.DeleteUserLogins(ctx, id); err != nil {
+		return err
+	}
+	// users of RegisterUser are kept by id
+	return s.Store.DeleteUser(ctx, id)
+}
+
+// logoutHandler clears the cookies and deletes the login of the request;
+// other logins of the user go on.
+// With OIDC it goes on to the end-session endpoint of the provider.
+// It answers only POST: a GET may come from an image of another site,
+// and SameSite=Lax cookies go with it.
+func (s *Service) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	to := s.PostLogoutRedirectURL
+	if to == "" {
+		to = "/"
+	}
+	// only the user who owns the cookies is deleted; the user cookie alone
+	// names a user, which may be guessed
+	if u, err := s.GetUser(r); err == nil {
+		if stored, err := s.Store.GetUser(r.Context(), u.storeKey()); err == nil && stored.IDToken != "" &&
+			s.OIDC != nil && s.OIDC.EndSessionURL != "" {
+			to = s.endSessionURL(stored.IDToken, to)
+		}
+		if err := s.Store.DeleteUser(r.Context(), u.storeKey()); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	s.clearCookie(w, s.UserCookie)
+	s.clearCookie(w, s.SessionCookie)
+	http.Redirect(w, r, to, http.StatusSeeOther)
+}
+
+// endSessionURL returns the URL of RP-initiated logout which comes back to after.
+func (s *Service) endSessionURL(idToken, after string) string {
+	q := url.Values{"id_token_hint": {idToken}, "client_id": {s.Config.ClientID}}
+	if u, err := url.Parse(after); err == nil && u.IsAbs() {
+		q.Set("post_logout_redirect_uri", after)
+	}
+	u, err := url.Parse(s.OIDC.EndSessionURL)
+	if err != nil {
+		return after
+	}
+	for k, v := range u.Query() {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (s *Service) clearCookie(w http.ResponseWriter, k string) {
+	http.SetCookie(w, &http.Cookie{
+		Name: k, Value: "",
+		Secure: s.Secure,
+		Domain: s.Domain,
+		Path:   s.Path,
+		MaxAge: -1,
+
+		HttpOnly: true,
+		SameSite: s.SameSite,
+	})
+}
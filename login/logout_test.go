@@ -0,0 +1,133 @@
+package login_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/masu-mi/gimmick.git/login"
+)
+
+// copyJar returns a jar with the cookies jar has for a.
+func copyJar(a *httptest.Server, jar http.CookieJar) http.CookieJar {
+	u, _ := url.Parse(a.URL)
+	c, _ := cookiejar.New(nil)
+	c.SetCookies(u, jar.Cookies(u))
+	return c
+}
+
+// logout posts to the logout of a by c and returns where it redirects to.
+func logout(t *testing.T, c *http.Client, a *httptest.Server) string {
+	t.Helper()
+	res, err := c.Post(a.URL+"/login/logout", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("logout answered %d", res.StatusCode)
+	}
+	return res.Header.Get("Location")
+}
+
+func TestLogout(t *testing.T) {
+	p := provider(t)
+	for _, stateless := range []bool{false, true} {
+		s := newService(p)
+		if stateless {
+			s.Keys = &login.Keyring{Keys: []login.Key{{ID: 1, Secret: bytes.Repeat([]byte("k"), 32)}}}
+			s.Revocable = true
+		}
+		a := app(t, s)
+		jar := signIn(t, a)
+		stolen := copyJar(a, jar)
+
+		c := browser()
+		c.Jar = jar
+		// an <img> of another site can't log the user out
+		if code := get(t, jar, a.URL+"/login/logout"); code != http.StatusMethodNotAllowed {
+			t.Errorf("stateless %v: logout by GET answered %d", stateless, code)
+		}
+		if code := get(t, jar, a.URL+"/private"); code != http.StatusOK {
+			t.Errorf("stateless %v: user after logout by GET got %d", stateless, code)
+		}
+		if to := logout(t, c, a); to != "/" {
+			t.Errorf("stateless %v: logout redirected to %s", stateless, to)
+		}
+		u, _ := url.Parse(a.URL)
+		if cs := jar.Cookies(u); len(cs) != 0 {
+			t.Errorf("stateless %v: cookies after logout %v", stateless, cs)
+		}
+		if code := get(t, stolen, a.URL+"/private"); code != http.StatusTemporaryRedirect {
+			t.Errorf("stateless %v: cookies of logged-out user got %d", stateless, code)
+		}
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	p := provider(t)
+	s := oidcService(t, p)
+	a := app(t, s)
+	jar1, jar2 := signIn(t, a), signIn(t, a)
+	if code := get(t, jar2, a.URL+"/private"); code != http.StatusOK {
+		t.Fatalf("logged-in user got %d", code)
+	}
+	if err := s.RevokeUser(context.Background(), "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	for i, jar := range []http.CookieJar{jar1, jar2} {
+		if code := get(t, jar, a.URL+"/private"); code != http.StatusTemporaryRedirect {
+			t.Errorf("session %d of revoked user got %d", i, code)
+		}
+	}
+}
+
+func TestLoginsOfOneUser(t *testing.T) {
+	p := provider(t)
+	s := oidcService(t, p)
+	a := app(t, s)
+	jar1, jar2 := signIn(t, a), signIn(t, a)
+	u, _ := url.Parse(a.URL)
+	for _, c := range jar1.Cookies(u) {
+		if c.Name == s.UserCookie && c.Value == "user-1" {
+			t.Error("user cookie is the subject")
+		}
+	}
+	if u1, u2 := userOf(t, s, a, jar1), userOf(t, s, a, jar2); u1.ID != u2.ID || u1.Key == u2.Key {
+		t.Errorf("logins of one user %+v and %+v", u1, u2)
+	}
+	c := browser()
+	c.Jar = jar1
+	logout(t, c, a)
+	if code := get(t, jar2, a.URL+"/private"); code != http.StatusOK {
+		t.Errorf("logout ended another login: %d", code)
+	}
+}
+
+func TestRPInitiatedLogout(t *testing.T) {
+	p := provider(t)
+	s := oidcService(t, p)
+	s.PostLogoutRedirectURL = "https://app.example.com/bye"
+	a := app(t, s)
+	c := browser()
+	c.Jar = signIn(t, a)
+
+	to, err := url.Parse(logout(t, c, a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := to.Query()
+	if to.Scheme+"://"+to.Host+to.Path != p.URL+"/logout" || q.Get("id_token_hint") == "" ||
+		q.Get("client_id") != "client" || q.Get("post_logout_redirect_uri") != "https://app.example.com/bye" {
+		t.Errorf("logout redirected to %s", to)
+	}
+
+	// without a user there's nothing to log out of at the provider
+	if to := logout(t, browser(), a); to != "https://app.example.com/bye" {
+		t.Errorf("anonymous logout redirected to %s", to)
+	}
+}
@@ -0,0 +1,79 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type userKey struct{}
+
+// NewContext returns a copy of ctx which carries u.
+func NewContext(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// UserFromContext returns the user put into ctx by the middleware of Service.
+func UserFromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(userKey{}).(*User)
+	return u, ok && u != nil
+}
+
+// Middleware puts the user of requests into their context, so handlers find
+// it with UserFromContext. Requests without a user start a login at path,
+// where AddService mounted s.
+func (s *Service) Middleware(path string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, err := s.GetUser(r)
+			if err != nil {
+				state, err := s.registerSession(r.Context(), r.URL.Path)
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				s.setCookie(w, s.SessionCookie, state)
+				http.Redirect(w, r, path, http.StatusTemporaryRedirect)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(NewContext(r.Context(), u)))
+		})
+	}
+}
+
+// APIMiddleware is Middleware for API routes: requests without a user are
+// answered 401 with a WWW-Authenticate challenge of realm instead of a redirect.
+func (s *Service) APIMiddleware(realm string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, err := s.GetUser(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Cookie realm=%q, cookie-name=%q", realm, s.UserCookie))
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(NewContext(r.Context(), u)))
+		})
+	}
+}
+
+// Middleware puts the user logged in through any of providers into the
+// context of requests. Requests without a user go to the chooser at path.
+// It panics when p has no provider.
+func (p *Providers) Middleware(path string) func(http.Handler) http.Handler {
+	s, err := p.first()
+	if err != nil {
+		panic(err)
+	}
+	return s.Middleware(path)
+}
+
+// APIMiddleware is Service.APIMiddleware for any of providers.
+// It panics when p has no provider.
+func (p *Providers) APIMiddleware(realm string) func(http.Handler) http.Handler {
+	s, err := p.first()
+	if err != nil {
+		panic(err)
+	}
+	return s.APIMiddleware(realm)
+}
@@ -0,0 +1,70 @@
+package login_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/masu-mi/gimmick.git/login"
+)
+
+func TestMiddleware(t *testing.T) {
+	p := provider(t)
+	s := oidcService(t, p)
+	m := http.NewServeMux()
+	login.AddService(m, "/login", s)
+	whoami := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := login.UserFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(u.ID))
+	})
+	m.Handle("/private", s.Middleware("/login")(whoami))
+	m.Handle("/api/whoami", s.APIMiddleware("api")(whoami))
+	a := httptest.NewServer(m)
+	t.Cleanup(a.Close)
+	s.Config.RedirectURL = a.URL + "/login/callback"
+
+	jar := signIn(t, a)
+	for _, path := range []string{"/private", "/api/whoami"} {
+		c := &http.Client{Jar: jar}
+		res, err := c.Get(a.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || string(b) != "user-1" {
+			t.Errorf("%s answered %d %q", path, res.StatusCode, b)
+		}
+	}
+
+	if code := get(t, nil, a.URL+"/private"); code != http.StatusTemporaryRedirect {
+		t.Errorf("anonymous user of a page got %d", code)
+	}
+	res, err := http.Get(a.URL + "/api/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") != `Cookie realm="api", cookie-name="_I"` {
+		t.Errorf("anonymous user of an API got %d %q", res.StatusCode, res.Header.Get("WWW-Authenticate"))
+	}
+	if len(res.Header["Set-Cookie"]) != 0 {
+		t.Errorf("API started a login: %v", res.Header["Set-Cookie"])
+	}
+}
+
+func TestUserFromContext(t *testing.T) {
+	if _, ok := login.UserFromContext(context.Background()); ok {
+		t.Error("user in an empty context")
+	}
+	u := &login.User{ID: "u1"}
+	if got, ok := login.UserFromContext(login.NewContext(context.Background(), u)); !ok || got != u {
+		t.Errorf("UserFromContext returned %+v, %v", got, ok)
+	}
+}
@@ -0,0 +1,298 @@
+package login
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrUnknownKey     = errors.New("ID token is signed by unknown key")
+)
+
+// clockSkew is the leeway of expiry of ID tokens.
+const clockSkew = time.Minute
+
+// Claims are claims of an ID token.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      Audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp,omitempty"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	// Extra holds the other claims.
+	Extra map[string]interface{} `json:"extra,omitempty"`
+}
+
+// Audience is aud claim, which is a string or an array of strings.
+type Audience []string
+
+// UnmarshalJSON accepts a string as well.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a Audience) contains(s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCProvider is an OpenID Connect provider.
+type OIDCProvider struct {
+	Issuer      string
+	AuthURL     string
+	TokenURL    string
+	JWKSURL     string
+	UserInfoURL string
+	// EndSessionURL is the endpoint of RP-initiated logout, if the provider has one.
+	EndSessionURL string
+
+	// Client fetches the discovery document and keys. Nil means http.DefaultClient.
+	Client *http.Client
+	// KeysMaxAge is how long fetched keys are used. Zero means 1 hour.
+	// Keys are fetched again earlier when a token names an unknown key.
+	KeysMaxAge time.Duration
+
+	m       sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// missed is when keys were fetched for an unknown key ID last.
+	missed time.Time
+}
+
+// minKeysInterval bounds fetches of keys for unknown key IDs.
+const minKeysInterval = 10 * time.Second
+
+// DiscoverOIDC loads the discovery document of issuer.
+func DiscoverOIDC(ctx context.Context, issuer string) (*OIDCProvider, error) {
+	p := &OIDCProvider{Issuer: issuer}
+	var doc struct {
+		Issuer        string `json:"issuer"`
+		AuthURL       string `json:"authorization_endpoint"`
+		TokenURL      string `json:"token_endpoint"`
+		JWKSURL       string `json:"jwks_uri"`
+		UserInfoURL   string `json:"userinfo_endpoint"`
+		EndSessionURL string `json:"end_session_endpoint"`
+	}
+	if err := p.fetch(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, err
+	}
+	if doc.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document is of issuer %q, not %q", doc.Issuer, issuer)
+	}
+	p.AuthURL, p.TokenURL, p.JWKSURL, p.UserInfoURL = doc.AuthURL, doc.TokenURL, doc.JWKSURL, doc.UserInfoURL
+	p.EndSessionURL = doc.EndSessionURL
+	return p, nil
+}
+
+// Endpoint returns the OAuth2 endpoint of p.
+func (p *OIDCProvider) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{AuthURL: p.AuthURL, TokenURL: p.TokenURL}
+}
+
+func (p *OIDCProvider) fetch(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	c := p.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	res, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// key returns the key of kid, fetching keys when they are old or kid is unknown.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	maxAge := p.KeysMaxAge
+	if maxAge <= 0 {
+		maxAge = time.Hour
+	}
+	k, ok := p.keys[kid]
+	if ok && time.Since(p.fetched) < maxAge {
+		return k, nil
+	}
+	if !ok && p.keys != nil {
+		if time.Since(p.missed) < minKeysInterval {
+			return nil, ErrUnknownKey
+		}
+		p.missed = time.Now()
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		if ok {
+			// keep using the old key while the provider is down
+			return k, nil
+		}
+		return nil, err
+	}
+	p.keys, p.fetched = keys, time.Now()
+	if k, ok = keys[kid]; !ok {
+		return nil, ErrUnknownKey
+	}
+	return k, nil
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.fetch(ctx, p.JWKSURL, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil || k.Crv != "P-256" {
+				continue
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				continue
+			}
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// Verify checks the signature and claims of raw ID token issued to clientID.
+// nonce must match the nonce claim unless it is empty.
+func (p *OIDCProvider) Verify(ctx context.Context, raw, clientID, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig) != nil {
+			return nil, ErrInvalidIDToken
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(pub, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, ErrInvalidIDToken
+		}
+	default:
+		return nil, ErrInvalidIDToken
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+	if err := decodeSegment(parts[1], &c.Extra); err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"iss", "sub", "aud", "azp", "exp", "iat", "nonce", "email", "email_verified", "name"} {
+		delete(c.Extra, k)
+	}
+	switch {
+	case c.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, c.Issuer)
+	case !c.Audience.contains(clientID):
+		return nil, fmt.Errorf("%w: not issued to %q", ErrInvalidIDToken, clientID)
+	case len(c.Audience) > 1 && c.AuthorizedBy != clientID:
+		return nil, fmt.Errorf("%w: authorized party isn't %q", ErrInvalidIDToken, clientID)
+	case c.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	case !time.Now().Before(time.Unix(c.Expiry, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case nonce != "" && c.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce doesn't match", ErrInvalidIDToken)
+	}
+	return &c, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ErrInvalidIDToken
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrInvalidIDToken
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package login_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masu-mi/gimmick.git/login"
+)
+
+func oidcService(t *testing.T, p *fakeProvider) *login.Service {
+	op, err := login.DiscoverOIDC(context.Background(), p.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newService(p)
+	s.OIDC = op
+	s.Config.Endpoint = op.Endpoint()
+	s.Config.Scopes = []string{"openid", "email"}
+	return s
+}
+
+// userOf returns the user s finds in cookies of jar for a.
+func userOf(t *testing.T, s *login.Service, a *httptest.Server, jar http.CookieJar) *login.User {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, a.URL+"/private", nil)
+	u, _ := url.Parse(a.URL)
+	for _, c := range jar.Cookies(u) {
+		r.AddCookie(c)
+	}
+	user, err := s.GetUser(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := provider(t)
+	for _, stateless := range []bool{false, true} {
+		s := oidcService(t, p)
+		if stateless {
+			s.Keys = &login.Keyring{Keys: []login.Key{{ID: 1, Secret: bytes.Repeat([]byte("k"), 32)}}}
+		}
+		a := app(t, s)
+		u := userOf(t, s, a, signIn(t, a))
+		if u.ID != "user-1" || u.Claims == nil || u.Claims.Subject != "user-1" || u.Claims.Email != "user-1@example.com" {
+			t.Fatalf("stateless %v: user %+v", stateless, u)
+		}
+		// the user cookie doesn't keep extra claims
+		if g, _ := u.Claims.Extra["groups"].([]interface{}); stateless && g != nil || !stateless && (len(g) != 1 || g[0] != "dev") {
+			t.Errorf("stateless %v: extra claims %+v", stateless, u.Claims.Extra)
+		}
+	}
+}
+
+func TestLargeClaims(t *testing.T) {
+	p := provider(t)
+	for i := 0; i < 500; i++ {
+		p.groups = append(p.groups, fmt.Sprintf("group-%03d", i))
+	}
+	for _, revocable := range []bool{false, true} {
+		s := oidcService(t, p)
+		s.Keys = &login.Keyring{Keys: []login.Key{{ID: 1, Secret: bytes.Repeat([]byte("k"), 32)}}}
+		s.Revocable = revocable
+		a := app(t, s)
+		jar := signIn(t, a)
+		cu, _ := url.Parse(a.URL)
+		for _, c := range jar.Cookies(cu) {
+			if len(c.String()) > 4096 {
+				t.Errorf("revocable %v: cookie %s of %d bytes", revocable, c.Name, len(c.String()))
+			}
+		}
+		u := userOf(t, s, a, jar)
+		if u.Claims.Email != "user-1@example.com" {
+			t.Errorf("revocable %v: claims %+v", revocable, u.Claims)
+		}
+		// Store keeps all the claims
+		if g, _ := u.Claims.Extra["groups"].([]interface{}); revocable != (len(g) == 500) {
+			t.Errorf("revocable %v: %d groups", revocable, len(g))
+		}
+	}
+}
+
+func TestDiscoverOIDC(t *testing.T) {
+	p := provider(t)
+	if _, err := login.DiscoverOIDC(context.Background(), p.URL+"/other"); err == nil {
+		t.Error("discovered a document of another issuer")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p := provider(t)
+	op, err := login.DiscoverOIDC(context.Background(), p.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	valid := p.sign(nil, p.claims("n1"))
+	c, err := op.Verify(ctx, valid, "client", "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "user-1" || len(c.Audience) != 1 || c.Audience[0] != "client" {
+		t.Errorf("claims %+v", c)
+	}
+
+	// the provider rotates keys; the new key is fetched at once
+	p.rotate(t)
+	if _, err := op.Verify(ctx, p.sign(nil, p.claims("n1")), "client", "n1"); err != nil {
+		t.Errorf("token of a rotated key: %v", err)
+	}
+
+	with := func(f string, v interface{}) map[string]interface{} {
+		c := p.claims("n1")
+		if v == nil {
+			delete(c, f)
+		} else {
+			c[f] = v
+		}
+		return c
+	}
+	tampered := []byte(valid)
+	tampered[len(tampered)-5] ^= 1
+	header, _ := json.Marshal(map[string]string{"alg": "none"})
+	payload, _ := json.Marshal(p.claims("n1"))
+	for name, raw := range map[string]string{
+		"issuer":           p.sign(nil, with("iss", "https://evil.example.com")),
+		"audience":         p.sign(nil, with("aud", "other")),
+		"azp":              p.sign(nil, with("aud", []string{"client", "other"})),
+		"expired":          p.sign(nil, with("exp", time.Now().Add(-time.Hour).Unix())),
+		"nonce":            p.sign(nil, with("nonce", "n2")),
+		"no subject":       p.sign(nil, with("sub", nil)),
+		"signature":        string(tampered),
+		"unknown key":      p.sign(map[string]interface{}{"kid": "k9"}, p.claims("n1")),
+		"algorithm":        p.sign(map[string]interface{}{"alg": "HS256"}, p.claims("n1")),
+		"unsigned":         base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + ".",
+		"malformed":        "a.b",
+		"malformed header": "!." + strings.SplitN(valid, ".", 2)[1],
+	} {
+		if _, err := op.Verify(ctx, raw, "client", "n1"); err == nil {
+			t.Errorf("%s: verified", name)
+		}
+	}
+	multi := with("aud", []string{"client", "other"})
+	multi["azp"] = "client"
+	if _, err := op.Verify(ctx, p.sign(nil, multi), "client", "n1"); err != nil {
+		t.Errorf("token of audiences authorized by client: %v", err)
+	}
+
+	if _, err := op.Verify(ctx, p.sign(map[string]interface{}{"kid": "k9"}, p.claims("n1")), "client", "n1"); !errors.Is(err, login.ErrUnknownKey) {
+		t.Errorf("token of an unknown key: %v", err)
+	}
+}
+
+func TestVerifyES256(t *testing.T) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC", "crv": "P-256", "kid": "e1",
+			"x": base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	defer jwks.Close()
+	op := &login.OIDCProvider{Issuer: jwks.URL, JWKSURL: jwks.URL}
+
+	hb, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "e1"})
+	cb, _ := json.Marshal(map[string]interface{}{"iss": jwks.URL, "sub": "s", "aud": "client", "exp": time.Now().Add(time.Hour).Unix()})
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	d := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, k, d[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	if _, err := op.Verify(context.Background(), signed+"."+base64.RawURLEncoding.EncodeToString(sig), "client", ""); err != nil {
+		t.Error(err)
+	}
+}
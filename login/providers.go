@@ -0,0 +1,166 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Providers lets users log in through one of several identity providers.
+// Each provider is a Service mounted under its own path segment; they share
+// Store, Keys and cookies, so a user logged in through any of them is found
+// by GetUser of the others.
+type Providers struct {
+	// Store, Keys and Revocable are given to services by Add.
+	Store     Store
+	Keys      *Keyring
+	Revocable bool
+
+	names    []string
+	services map[string]*Service
+}
+
+// NewProviders creates Providers without providers.
+func NewProviders() *Providers {
+	return &Providers{
+		Store:    NewMemoryStore(),
+		services: map[string]*Service{},
+	}
+}
+
+// Add registers s as the provider of name.
+// The cookie settings of s must be the same as the other providers'.
+// Add replaces Store and Revocable of s by those of p, and sets Name and
+// Keys of s; s with another Name or other Keys is refused.
+func (p *Providers) Add(name string, s *Service) error {
+	if name == "" || name == "logout" || strings.ContainsAny(name, "/:") {
+		return errors.New("invalid provider name " + name)
+	}
+	if _, ok := p.services[name]; ok {
+		return errors.New("duplicated provider " + name)
+	}
+	if s.Name != "" && s.Name != name {
+		return errors.New("provider " + name + " is named " + s.Name)
+	}
+	if s.Keys != nil && s.Keys != p.Keys {
+		return errors.New("provider " + name + " has its own keys")
+	}
+	s.Name, s.Store, s.Keys, s.Revocable = name, p.Store, p.Keys, p.Revocable
+	p.names = append(p.names, name)
+	p.services[name] = s
+	return nil
+}
+
+// Names returns names of providers in the order they are added.
+func (p *Providers) Names() []string {
+	return append([]string(nil), p.names...)
+}
+
+// Service returns the service of the provider of name.
+func (p *Providers) Service(name string) (*Service, bool) {
+	s, ok := p.services[name]
+	return s, ok
+}
+
+// ErrNoProvider is returned by Providers without providers.
+var ErrNoProvider = errors.New("login: no provider")
+
+// first returns the service which finds users of any provider.
+func (p *Providers) first() (*Service, error) {
+	if len(p.names) == 0 {
+		return nil, ErrNoProvider
+	}
+	return p.services[p.names[0]], nil
+}
+
+// GetUser gets the user logged in through any of providers.
+func (p *Providers) GetUser(r *http.Request) (*User, error) {
+	s, err := p.first()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(r)
+}
+
+// TokenSource returns the token source of u by the provider which authenticated u.
+func (p *Providers) TokenSource(ctx context.Context, u *User) (oauth2.TokenSource, error) {
+	s, ok := p.services[u.Provider]
+	if !ok {
+		return nil, errors.New("unknown provider " + u.Provider)
+	}
+	return s.TokenSource(ctx, u), nil
+}
+
+// RevokeUser ends all the sessions of the user of id.
+func (p *Providers) RevokeUser(ctx context.Context, id string) error {
+	return (&Service{Store: p.Store}).RevokeUser(ctx, id)
+}
+
+var chooserPage = template.Must(template.New("chooser").Parse(`<!DOCTYPE html>
+<html><head><title>Log in</title></head><body>
+<ul>
+{{range .}}<li><a href="{{.URL}}">Log in with {{.Name}}</a></li>
+{{end}}</ul>
+</body></html>
+`))
+
+// AddProviders adds providers to mux: the chooser at path, each provider at
+// path/name and the logout of any provider at path/logout.
+// It panics when p has no provider.
+func AddProviders(m *http.ServeMux, path string, p *Providers) func(http.HandlerFunc) http.HandlerFunc {
+	s, err := p.first()
+	if err != nil {
+		panic(err)
+	}
+	for _, name := range p.names {
+		AddService(m, path+"/"+name, p.services[name])
+	}
+	m.HandleFunc(path, p.chooser(path))
+	m.HandleFunc(path+"/logout", p.logoutHandler)
+	return s.authenticator(path)
+}
+
+// chooser lists links to providers, starting a login when r isn't in one.
+func (p *Providers) chooser(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := p.first()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if _, _, err := s.pendingOf(r); err != nil {
+			state, err := s.registerSession(r.Context(), "/")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			s.setCookie(w, s.SessionCookie, state)
+		}
+		type link struct{ Name, URL string }
+		var links []link
+		for _, name := range p.names {
+			links = append(links, link{name, path + "/" + name})
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		chooserPage.Execute(w, links)
+	}
+}
+
+// logoutHandler logs out through the provider which authenticated the user.
+func (p *Providers) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := p.first()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if u, err := s.GetUser(r); err == nil {
+		if by, ok := p.services[u.Provider]; ok {
+			s = by
+		}
+	}
+	s.logoutHandler(w, r)
+}
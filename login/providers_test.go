@@ -0,0 +1,143 @@
+package login_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/masu-mi/gimmick.git/login"
+)
+
+// providersApp serves ps at /login and a page which needs login at /private.
+func providersApp(t *testing.T, ps *login.Providers) *httptest.Server {
+	m := http.NewServeMux()
+	auth := login.AddProviders(m, "/login", ps)
+	m.HandleFunc("/private", auth(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("private"))
+	}))
+	a := httptest.NewServer(m)
+	t.Cleanup(a.Close)
+	for _, name := range ps.Names() {
+		s, _ := ps.Service(name)
+		s.Config.RedirectURL = a.URL + "/login/" + name + "/callback"
+	}
+	return a
+}
+
+func TestProviders(t *testing.T) {
+	corp, github := provider(t), provider(t)
+	for _, stateless := range []bool{false, true} {
+		ps := login.NewProviders()
+		if stateless {
+			ps.Keys = &login.Keyring{Keys: []login.Key{{ID: 1, Secret: bytes.Repeat([]byte("k"), 32)}}}
+		}
+		if err := ps.Add("corp", oidcService(t, corp)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.Add("github", newService(github)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.Add("github", newService(github)); err == nil {
+			t.Error("added a provider twice")
+		}
+		a := providersApp(t, ps)
+
+		for _, name := range []string{"corp", "github"} {
+			c := browser()
+			if to := follow(t, c, a.URL+"/private"); to != "/login" {
+				t.Fatalf("private redirected to %s", to)
+			}
+			res, err := c.Get(a.URL + "/login")
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if !strings.Contains(string(page), `href="/login/corp"`) || !strings.Contains(string(page), `href="/login/github"`) {
+				t.Fatalf("chooser page %s", page)
+			}
+			if to := follow(t, c, follow(t, c, follow(t, c, a.URL+"/login/"+name))); to != "/private" {
+				t.Fatalf("callback of %s redirected to %s", name, to)
+			}
+			s, _ := ps.Service(name)
+			u := userOf(t, s, a, c.Jar)
+			if u.Provider != name || !strings.HasPrefix(u.ID, name+":") {
+				t.Errorf("stateless %v: user of %s %+v", stateless, name, u)
+			}
+			if name == "corp" && u.ID != "corp:user-1" {
+				t.Errorf("stateless %v: user of corp %s", stateless, u.ID)
+			}
+			if _, err := ps.TokenSource(context.Background(), u); err != nil {
+				t.Errorf("stateless %v: token source of %s: %v", stateless, name, err)
+			}
+			if name == "corp" && !stateless {
+				if err := ps.RevokeUser(context.Background(), u.ID); err != nil {
+					t.Fatal(err)
+				}
+				if code := get(t, c.Jar, a.URL+"/private"); code != http.StatusTemporaryRedirect {
+					t.Errorf("revoked user of corp got %d", code)
+				}
+			}
+		}
+	}
+}
+
+func TestProvidersChooser(t *testing.T) {
+	ps := login.NewProviders()
+	ps.Add("github", newService(provider(t)))
+	a := providersApp(t, ps)
+
+	// the chooser starts a login for a user coming from nowhere
+	c := browser()
+	res, err := c.Get(a.URL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if to := follow(t, c, follow(t, c, follow(t, c, a.URL+"/login/github"))); to != "/" {
+		t.Errorf("callback redirected to %s", to)
+	}
+	if code := get(t, nil, a.URL+"/login/github"); code != http.StatusBadRequest {
+		t.Errorf("provider without login answered %d", code)
+	}
+}
+
+func TestEmptyProviders(t *testing.T) {
+	ps := login.NewProviders()
+	if _, err := ps.GetUser(httptest.NewRequest(http.MethodGet, "/", nil)); err != login.ErrNoProvider {
+		t.Errorf("user of no provider: %v", err)
+	}
+	for name, f := range map[string]func(){
+		"AddProviders":  func() { login.AddProviders(http.NewServeMux(), "/login", ps) },
+		"Middleware":    func() { ps.Middleware("/login") },
+		"APIMiddleware": func() { ps.APIMiddleware("api") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s of no provider didn't panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestAddConflictingProvider(t *testing.T) {
+	p := provider(t)
+	ps := login.NewProviders()
+	named := newService(p)
+	named.Name = "corp"
+	if err := ps.Add("github", named); err == nil {
+		t.Error("added a provider of another name")
+	}
+	keyed := newService(p)
+	keyed.Keys = statelessKeys()
+	if err := ps.Add("github", keyed); err == nil {
+		t.Error("added a provider of other keys")
+	}
+}
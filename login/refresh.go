@@ -0,0 +1,90 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrReauthenticate is returned by token sources of users who have to log in again.
+var ErrReauthenticate = errors.New("token can't be refreshed; log in again")
+
+// TokenSource returns a source of u's token which refreshes it through Config.
+// Refreshed tokens are saved to Store. When the provider rejects a refresh, u is
+// deleted from Store, so GetUser doesn't find u any more, and the source returns
+// an error wrapping ErrReauthenticate. Other failures, e.g. an unavailable
+// provider, are returned as they are and u stays.
+func (s *Service) TokenSource(ctx context.Context, u *User) oauth2.TokenSource {
+	return &userTokenSource{s: s, ctx: ctx, u: u}
+}
+
+// Client returns an HTTP client authorized as u with TokenSource.
+func (s *Service) Client(ctx context.Context, u *User) *http.Client {
+	return oauth2.NewClient(ctx, s.TokenSource(ctx, u))
+}
+
+type userTokenSource struct {
+	s   *Service
+	ctx context.Context
+	u   *User
+
+	m    sync.Mutex
+	src  oauth2.TokenSource
+	last *oauth2.Token
+}
+
+func (t *userTokenSource) Token() (*oauth2.Token, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.src == nil {
+		tok, err := t.s.Token(t.ctx, t.u)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrReauthenticate, err)
+		}
+		t.last = tok
+		t.src = t.s.Config.TokenSource(t.ctx, tok)
+	}
+	tok, err := t.src.Token()
+	if err != nil {
+		if !rejected(err) {
+			return nil, err
+		}
+		t.s.Store.DeleteUser(t.ctx, t.u.storeKey())
+		return nil, fmt.Errorf("%w: %v", ErrReauthenticate, err)
+	}
+	if tok != t.last {
+		t.last = tok
+		// the stored user has fields the user of a stateless cookie lacks
+		u, err := t.s.Store.GetUser(t.ctx, t.u.storeKey())
+		if err != nil {
+			return nil, err
+		}
+		u.Token = tok
+		if err := t.s.Store.PutUser(t.ctx, t.u.storeKey(), u); err != nil {
+			return nil, err
+		}
+		t.u.Token = tok
+	}
+	return tok, nil
+}
+
+// rejected reports whether the provider refused the refresh token itself,
+// e.g. by invalid_grant, rather than failing to answer.
+func rejected(err error) bool {
+	var re *oauth2.RetrieveError
+	if !errors.As(err, &re) {
+		return false
+	}
+	if re.ErrorCode == "invalid_grant" {
+		return true
+	}
+	if re.Response == nil {
+		return false
+	}
+	code := re.Response.StatusCode
+	return code/100 == 4 && code != http.StatusTooManyRequests
+}
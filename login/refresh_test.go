@@ -0,0 +1,57 @@
+package login_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/masu-mi/gimmick.git/login"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenRefresh(t *testing.T) {
+	s := newService(provider(t))
+	ctx := context.Background()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	expired := time.Now().Add(-time.Minute)
+	s.Store.PutUser(ctx, "u1", login.User{Token: &oauth2.Token{AccessToken: "old", RefreshToken: "rt", Expiry: expired}, Session: "s1"})
+	u := &login.User{ID: "u1", Session: "s1"}
+	res, err := s.Client(ctx, u).Get(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "Bearer at-refreshed" {
+		t.Errorf("request was authorized by %q", b)
+	}
+	if stored, err := s.Store.GetUser(ctx, "u1"); err != nil || stored.AccessToken != "at-refreshed" || stored.Session != "s1" {
+		t.Errorf("refreshed token wasn't saved: %+v, %v", stored, err)
+	}
+
+	s.Store.PutUser(ctx, "u2", login.User{Token: &oauth2.Token{AccessToken: "old", RefreshToken: "revoked", Expiry: expired}, Session: "s2"})
+	ts := s.TokenSource(ctx, &login.User{ID: "u2", Session: "s2"})
+	if _, err := ts.Token(); !errors.Is(err, login.ErrReauthenticate) {
+		t.Errorf("failed refresh returned %v", err)
+	}
+	if _, err := s.Store.GetUser(ctx, "u2"); err != login.ErrNotFound {
+		t.Errorf("user of a failed refresh stays: %v", err)
+	}
+
+	s.Store.PutUser(ctx, "u3", login.User{Token: &oauth2.Token{AccessToken: "old", RefreshToken: "unavailable", Expiry: expired}, Session: "s3"})
+	ts = s.TokenSource(ctx, &login.User{ID: "u3", Session: "s3"})
+	if _, err := ts.Token(); err == nil || errors.Is(err, login.ErrReauthenticate) {
+		t.Errorf("refresh from an unavailable provider returned %v", err)
+	}
+	if _, err := s.Store.GetUser(ctx, "u3"); err != nil {
+		t.Errorf("user of an unavailable provider was deleted: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package login
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	ErrInvalidCookie = errors.New("invalid sealed cookie")
+	ErrNoKey         = errors.New("no key to seal cookies")
+	ErrShortKey      = errors.New("key secret is shorter than 32 bytes")
+)
+
+// Key is a secret of sealed cookies.
+// Keys for encryption and authentication are derived from Secret.
+type Key struct {
+	ID     uint32
+	Secret []byte
+}
+
+func (k Key) derive(label string) []byte {
+	m := hmac.New(sha256.New, k.Secret)
+	m.Write([]byte(label))
+	return m.Sum(nil)
+}
+
+func (k Key) aead() (cipher.AEAD, error) {
+	if len(k.Secret) < 32 {
+		return nil, ErrShortKey
+	}
+	b, err := aes.NewCipher(k.derive("login cookie encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+func (k Key) mac(name string, body []byte) []byte {
+	m := hmac.New(sha256.New, k.derive("login cookie authentication"))
+	m.Write([]byte(name))
+	m.Write([]byte{0})
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// Keyring seals cookies with its first key and opens cookies sealed with
+// any of its keys. Keys rotate by putting a new key first and dropping
+// the old one once cookies sealed with it have expired.
+type Keyring struct {
+	Keys []Key
+}
+
+// Seal encrypts v as JSON into a value of cookie name.
+// The value is the key ID, the nonce and the AES-GCM ciphertext,
+// followed by their HMAC-SHA256, in unpadded base64url.
+func (r *Keyring) Seal(name string, v interface{}) (string, error) {
+	if len(r.Keys) == 0 {
+		return "", ErrNoKey
+	}
+	k := r.Keys[0]
+	aead, err := k.aead()
+	if err != nil {
+		return "", err
+	}
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	head := make([]byte, 4+aead.NonceSize())
+	binary.BigEndian.PutUint32(head, k.ID)
+	if _, err := rand.Read(head[4:]); err != nil {
+		return "", err
+	}
+	body := aead.Seal(head, head[4:], plain, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(append(body, k.mac(name, body)...)), nil
+}
+
+// Open decrypts value of cookie name sealed by Seal into v.
+// Values sealed for another cookie name don't open.
+func (r *Keyring) Open(name, value string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) < 4+sha256.Size {
+		return ErrInvalidCookie
+	}
+	id := binary.BigEndian.Uint32(b)
+	for _, k := range r.Keys {
+		if k.ID != id {
+			continue
+		}
+		aead, err := k.aead()
+		if err != nil {
+			return err
+		}
+		body, mac := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+		if len(body) < 4+aead.NonceSize()+aead.Overhead() || !hmac.Equal(mac, k.mac(name, body)) {
+			return ErrInvalidCookie
+		}
+		nonce := body[4 : 4+aead.NonceSize()]
+		plain, err := aead.Open(nil, nonce, body[4+aead.NonceSize():], []byte(name))
+		if err != nil {
+			return ErrInvalidCookie
+		}
+		return json.Unmarshal(plain, v)
+	}
+	return ErrInvalidCookie
+}
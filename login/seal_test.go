@@ -0,0 +1,55 @@
+package login_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/masu-mi/gimmick.git/login"
+)
+
+func TestKeyring(t *testing.T) {
+	old := login.Key{ID: 1, Secret: bytes.Repeat([]byte("o"), 32)}
+	cur := login.Key{ID: 2, Secret: bytes.Repeat([]byte("c"), 32)}
+	type payload struct{ V string }
+
+	v, err := (&login.Keyring{Keys: []login.Key{old}}).Seal("_I", payload{"x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := &login.Keyring{Keys: []login.Key{cur, old}}
+	var p payload
+	if err := rotated.Open("_I", v, &p); err != nil || p.V != "x" {
+		t.Errorf("cookie of the old key didn't open: %+v, %v", p, err)
+	}
+	if err := (&login.Keyring{Keys: []login.Key{cur}}).Open("_I", v, &p); err != login.ErrInvalidCookie {
+		t.Errorf("cookie of a dropped key opened: %v", err)
+	}
+	if err := rotated.Open("_s", v, &p); err != login.ErrInvalidCookie {
+		t.Errorf("cookie opened under another name: %v", err)
+	}
+
+	w, err := rotated.Seal("_I", payload{"y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&login.Keyring{Keys: []login.Key{cur}}).Open("_I", w, &p); err != nil || p.V != "y" {
+		t.Errorf("cookie wasn't sealed with the first key: %+v, %v", p, err)
+	}
+	b, _ := base64.RawURLEncoding.DecodeString(w)
+	for _, i := range []int{0, 8, len(b) / 2, len(b) - 1} {
+		tampered := append([]byte{}, b...)
+		tampered[i] ^= 1
+		if err := rotated.Open("_I", base64.RawURLEncoding.EncodeToString(tampered), &p); err != login.ErrInvalidCookie {
+			t.Errorf("tampered byte %d: %v", i, err)
+		}
+	}
+
+	if _, err := (&login.Keyring{}).Seal("_I", payload{}); err != login.ErrNoKey {
+		t.Errorf("sealed without keys: %v", err)
+	}
+	short := &login.Keyring{Keys: []login.Key{{ID: 3, Secret: []byte("short")}}}
+	if _, err := short.Seal("_I", payload{}); err != login.ErrShortKey {
+		t.Errorf("sealed with a short key: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package login
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLStore is a Store in tables of a SQL database.
+// Values are kept as JSON, so any database with TEXT columns works.
+type SQLStore struct {
+	db *sql.DB
+	// numbered is set for drivers taking $1, $2, ... instead of ?.
+	numbered bool
+	// upsert is the clause turning an INSERT into an upsert and assign the
+	// format of its assignments; drivers without one update and insert the
+	// row in turn.
+	upsert, assign string
+}
+
+var _ Store = (*SQLStore)(nil)
+
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS login_pending (k VARCHAR(255) PRIMARY KEY, v TEXT NOT NULL)`,
+}
+
+// NewSQLStore creates tables of the store in db unless they exist.
+// driver is the name db was opened with; it chooses the placeholder style
+// and the upsert syntax.
+func NewSQLStore(db *sql.DB, driver string) (*SQLStore, error) {
+	s := &SQLStore{db: db}
+	switch driver {
+	case "postgres", "pgx":
+		s.numbered = true
+		s.upsert, s.assign = ` ON CONFLICT (k) DO UPDATE SET `, `%[1]s = excluded.%[1]s`
+	case "sqlite", "sqlite3":
+		s.upsert, s.assign = ` ON CONFLICT (k) DO UPDATE SET `, `%[1]s = excluded.%[1]s`
+	case "mysql":
+		s.upsert, s.assign = ` ON DUPLICATE KEY UPDATE `, `%[1]s = VALUES(%[1]s)`
+	}
+	for _, q := range sqlSchema {
+		if _, err := db.Exec(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.createUsers(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// createUsers creates login_users, whose uid column indexes users by
+// User.ID, or adds the column to the table of an older version.
+func (s *SQLStore) createUsers() error {
+	if s.selects(`uid`) {
+		return nil
+	}
+	if !s.selects(`k`) {
+		if _, err := s.db.Exec(`CREATE TABLE login_users (k VARCHAR(255) PRIMARY KEY, uid VARCHAR(255), v TEXT NOT NULL)`); err != nil {
+			return err
+		}
+	} else {
+		if _, err := s.db.Exec(`ALTER TABLE login_users ADD COLUMN uid VARCHAR(255)`); err != nil {
+			return err
+		}
+		if err := s.fillUID(); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.Exec(`CREATE INDEX login_users_uid ON login_users (uid)`)
+	return err
+}
+
+// selects reports whether column c of login_users can be selected.
+func (s *SQLStore) selects(c string) bool {
+	rows, err := s.db.Query(`SELECT ` + c + ` FROM login_users WHERE 1 = 0`)
+	if err != nil {
+		return false
+	}
+	for rows.Next() {
+	}
+	err = rows.Err()
+	rows.Close()
+	return err == nil
+}
+
+// fillUID sets uid of the users stored before the column was added.
+func (s *SQLStore) fillUID() error {
+	rows, err := s.db.Query(`SELECT k, v FROM login_users`)
+	if err != nil {
+		return err
+	}
+	uids := map[string]string{}
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			rows.Close()
+			return err
+		}
+		var u User
+		if err := json.Unmarshal([]byte(v), &u); err != nil {
+			rows.Close()
+			return err
+		}
+		if u.ID != "" {
+			uids[k] = u.ID
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for k, uid := range uids {
+		if _, err := s.db.Exec(s.bind(`UPDATE login_users SET uid = ? WHERE k = ?`), uid, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bind rewrites ? placeholders of q for the driver.
+func (s *SQLStore) bind(q string) string {
+	if !s.numbered {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// put replaces the row of k; cols are the other columns of the row and
+// vals their values.
+func (s *SQLStore) put(ctx context.Context, table, k string, cols []string, vals ...interface{}) error {
+	insert := s.bind(`INSERT INTO ` + table + ` (k, ` + strings.Join(cols, ", ") + `) VALUES (?` + strings.Repeat(", ?", len(cols)) + `)`)
+	args := append([]interface{}{k}, vals...)
+	set := make([]string, len(cols))
+	if s.upsert != "" {
+		for i, c := range cols {
+			set[i] = fmt.Sprintf(s.assign, c)
+		}
+		_, err := s.db.ExecContext(ctx, insert+s.upsert+strings.Join(set, ", "), args...)
+		return err
+	}
+	for i, c := range cols {
+		set[i] = c + ` = ?`
+	}
+	update := s.bind(`UPDATE ` + table + ` SET ` + strings.Join(set, ", ") + ` WHERE k = ?`)
+	updateArgs := append(append([]interface{}{}, vals...), k)
+	for {
+		res, err := s.db.ExecContext(ctx, update, updateArgs...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+		_, err = s.db.ExecContext(ctx, insert, args...)
+		if err == nil {
+			return nil
+		}
+		// another put may have inserted k since the update; it is updated then
+		if _, gerr := s.rawGet(ctx, table, k); gerr != nil {
+			return err
+		}
+	}
+}
+
+func (s *SQLStore) get(ctx context.Context, table, k string, v interface{}) error {
+	b, err := s.rawGet(ctx, table, k)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(b), v)
+}
+
+// rawGet returns the JSON of the row of k.
+func (s *SQLStore) rawGet(ctx context.Context, table, k string) (string, error) {
+	var b string
+	err := s.db.QueryRowContext(ctx, s.bind(`SELECT v FROM `+table+` WHERE k = ?`), k).Scan(&b)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return b, err
+}
+
+func (s *SQLStore) delete(ctx context.Context, table, k string) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM `+table+` WHERE k = ?`), k)
+	return err
+}
+
+// PutPending stores p by state.
+func (s *SQLStore) PutPending(ctx context.Context, state string, p Pending) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, "login_pending", state, []string{"v"}, string(b))
+}
+
+// GetPending returns the login of state.
+func (s *SQLStore) GetPending(ctx context.Context, state string) (Pending, error) {
+	var p Pending
+	err := s.get(ctx, "login_pending", state, &p)
+	return p, err
+}
+
+// DeletePending deletes the login of state.
+func (s *SQLStore) DeletePending(ctx context.Context, state string) error {
+	return s.delete(ctx, "login_pending", state)
+}
+
+// PutUser stores u by id.
+func (s *SQLStore) PutUser(ctx context.Context, id string, u User) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	uid := sql.NullString{String: u.ID, Valid: u.ID != ""}
+	return s.put(ctx, "login_users", id, []string{"uid", "v"}, uid, string(b))
+}
+
+// GetUser returns the user of id.
+func (s *SQLStore) GetUser(ctx context.Context, id string) (User, error) {
+	var u User
+	err := s.get(ctx, "login_users", id, &u)
+	return u, err
+}
+
+// DeleteUser deletes the user of id.
+func (s *SQLStore) DeleteUser(ctx context.Context, id string) error {
+	return s.delete(ctx, "login_users", id)
+}
+
+// DeleteUserLogins deletes the users of User.ID id.
+func (s *SQLStore) DeleteUserLogins(ctx context.Context, id string) error {
+	if id == "" {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM login_users WHERE uid = ?`), id)
+	return err
+}
+
+// Sweep deletes expired pending logins and users.
+func (s *SQLStore) Sweep(ctx context.Context, pendingBefore time.Time, expired func(User) bool) (Counts, error) {
+	var n Counts
+	var err error
+	n.Pending, err = s.sweep(ctx, "login_pending", func(v string) (bool, error) {
+		var p Pending
+		err := json.Unmarshal([]byte(v), &p)
+		return err == nil && p.StartAt.Before(pendingBefore), err
+	})
+	if err != nil {
+		return n, err
+	}
+	n.Users, err = s.sweep(ctx, "login_users", func(v string) (bool, error) {
+		var u User
+		err := json.Unmarshal([]byte(v), &u)
+		return err == nil && expired(u), err
+	})
+	return n, err
+}
+
+// sweep deletes rows of table expired reports and returns the number left.
+func (s *SQLStore) sweep(ctx context.Context, table string, expired func(string) (bool, error)) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT k, v FROM `+table)
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	left := 0
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		x, err := expired(v)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if x {
+			keys = append(keys, k)
+		} else {
+			left++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	// rows are deleted after reading them; some drivers can't do both at once
+	for _, k := range keys {
+		if err := s.delete(ctx, table, k); err != nil {
+			return 0, err
+		}
+	}
+	return left, nil
+}
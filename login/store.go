@@ -0,0 +1,160 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Store for unknown states and users.
+var ErrNotFound = errors.New("not found in store")
+
+// Pending is a login waiting for the callback from the provider.
+type Pending struct {
+	StartAt time.Time
+	// From is the path the login started at.
+	From string
+	// Verifier is the PKCE code verifier of the login.
+	Verifier string
+	// Nonce binds the ID token to the login with OIDC.
+	Nonce string
+
+	// used is set for sealed logins which are completed.
+	used bool
+}
+
+// Store keeps pending logins by state and logged-in users by id.
+// Implementations must be safe for concurrent use; storetest checks them.
+type Store interface {
+	PutPending(ctx context.Context, state string, p Pending) error
+	GetPending(ctx context.Context, state string) (Pending, error)
+	DeletePending(ctx context.Context, state string) error
+
+	PutUser(ctx context.Context, id string, u User) error
+	GetUser(ctx context.Context, id string) (User, error)
+	DeleteUser(ctx context.Context, id string) error
+	// DeleteUserLogins deletes the users of User.ID id, whatever keys they
+	// are stored by. Users without ID are left.
+	DeleteUserLogins(ctx context.Context, id string) error
+
+	// Sweep deletes pending logins started before pendingBefore and users
+	// expired reports, and returns the counts of those left.
+	Sweep(ctx context.Context, pendingBefore time.Time, expired func(User) bool) (Counts, error)
+}
+
+// Counts are numbers of pending logins and users in Store.
+type Counts struct {
+	Pending int
+	Users   int
+}
+
+// MemoryStore is a Store in process-local maps.
+type MemoryStore struct {
+	mPend   sync.RWMutex
+	pending map[string]Pending
+
+	mUser sync.RWMutex
+	users map[string]User
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pending: map[string]Pending{},
+		users:   map[string]User{},
+	}
+}
+
+// PutPending stores p by state.
+func (m *MemoryStore) PutPending(_ context.Context, state string, p Pending) error {
+	m.mPend.Lock()
+	defer m.mPend.Unlock()
+	m.pending[state] = p
+	return nil
+}
+
+// GetPending returns the login of state.
+func (m *MemoryStore) GetPending(_ context.Context, state string) (Pending, error) {
+	m.mPend.RLock()
+	defer m.mPend.RUnlock()
+	p, ok := m.pending[state]
+	if !ok {
+		return Pending{}, ErrNotFound
+	}
+	return p, nil
+}
+
+// DeletePending deletes the login of state.
+func (m *MemoryStore) DeletePending(_ context.Context, state string) error {
+	m.mPend.Lock()
+	defer m.mPend.Unlock()
+	delete(m.pending, state)
+	return nil
+}
+
+// PutUser stores u by id.
+func (m *MemoryStore) PutUser(_ context.Context, id string, u User) error {
+	m.mUser.Lock()
+	defer m.mUser.Unlock()
+	m.users[id] = u
+	return nil
+}
+
+// GetUser returns the user of id.
+func (m *MemoryStore) GetUser(_ context.Context, id string) (User, error) {
+	m.mUser.RLock()
+	defer m.mUser.RUnlock()
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+// DeleteUser deletes the user of id.
+func (m *MemoryStore) DeleteUser(_ context.Context, id string) error {
+	m.mUser.Lock()
+	defer m.mUser.Unlock()
+	delete(m.users, id)
+	return nil
+}
+
+// DeleteUserLogins deletes the users of User.ID id.
+func (m *MemoryStore) DeleteUserLogins(_ context.Context, id string) error {
+	if id == "" {
+		return nil
+	}
+	m.mUser.Lock()
+	defer m.mUser.Unlock()
+	for k, u := range m.users {
+		if u.ID == id {
+			delete(m.users, k)
+		}
+	}
+	return nil
+}
+
+// Sweep deletes expired pending logins and users.
+func (m *MemoryStore) Sweep(_ context.Context, pendingBefore time.Time, expired func(User) bool) (Counts, error) {
+	m.mPend.Lock()
+	for state, p := range m.pending {
+		if p.StartAt.Before(pendingBefore) {
+			delete(m.pending, state)
+		}
+	}
+	n := Counts{Pending: len(m.pending)}
+	m.mPend.Unlock()
+
+	m.mUser.Lock()
+	defer m.mUser.Unlock()
+	for id, u := range m.users {
+		if expired(u) {
+			delete(m.users, id)
+		}
+	}
+	n.Users = len(m.users)
+	return n, nil
+}
@@ -0,0 +1,110 @@
+package login_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/masu-mi/gimmick.git/login"
+	"github.com/masu-mi/gimmick.git/login/storetest"
+	bolt "go.etcd.io/bbolt"
+	_ "modernc.org/sqlite"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) login.Store {
+		return login.NewMemoryStore()
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) login.Store {
+		s, err := login.OpenFileStore(filepath.Join(t.TempDir(), "login.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestSQLStore(t *testing.T) {
+	// "" is a driver without a known upsert
+	for _, driver := range []string{"sqlite", ""} {
+		t.Run(driver, func(t *testing.T) {
+			storetest.TestStore(t, func(t *testing.T) login.Store {
+				// sqlite takes a single writer; the others wait for it
+				dsn := filepath.Join(t.TempDir(), "login.sqlite") + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)"
+				db, err := sql.Open("sqlite", dsn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { db.Close() })
+				db.SetMaxOpenConns(4)
+				s, err := login.NewSQLStore(db, driver)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			})
+		})
+	}
+}
+
+// TestStoreUpgrade opens stores of the version without the index of users by ID.
+func TestStoreUpgrade(t *testing.T) {
+	ctx := context.Background()
+	old := `{"ID":"a"}`
+
+	path := filepath.Join(t.TempDir(), "login.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("k1"), []byte(old))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := login.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sdb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "login.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+	if _, err := sdb.Exec(`CREATE TABLE login_users (k VARCHAR(255) PRIMARY KEY, v TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sdb.Exec(`INSERT INTO login_users (k, v) VALUES ('k1', ?)`, old); err != nil {
+		t.Fatal(err)
+	}
+	s, err := login.NewSQLStore(sdb, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]login.Store{"file": f, "sql": s} {
+		if err := s.DeleteUserLogins(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetUser(ctx, "k1"); err != login.ErrNotFound {
+			t.Errorf("%s: GetUser of a deleted login: %v", name, err)
+		}
+	}
+	// the store is opened again as it is
+	if _, err := login.NewSQLStore(sdb, "sqlite"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,207 @@
+// Package storetest checks implementations of login.Store.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masu-mi/gimmick.git/login"
+	"golang.org/x/oauth2"
+)
+
+// TestStore runs the conformance tests against stores made by open.
+// open is called once per subtest and returns an empty store.
+func TestStore(t *testing.T, open func(t *testing.T) login.Store) {
+	t.Run("Pending", func(t *testing.T) { testPending(t, open(t)) })
+	t.Run("User", func(t *testing.T) { testUser(t, open(t)) })
+	t.Run("UserLogins", func(t *testing.T) { testUserLogins(t, open(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, open(t)) })
+	t.Run("Sweep", func(t *testing.T) { testSweep(t, open(t)) })
+}
+
+func testPending(t *testing.T, s login.Store) {
+	ctx := context.Background()
+	if _, err := s.GetPending(ctx, "unknown"); err != login.ErrNotFound {
+		t.Errorf("GetPending of unknown state: %v", err)
+	}
+	at := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	if err := s.PutPending(ctx, "s1", login.Pending{StartAt: at, From: "/a", Verifier: "v", Nonce: "n"}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.GetPending(ctx, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.StartAt.Equal(at) || p.From != "/a" || p.Verifier != "v" || p.Nonce != "n" {
+		t.Errorf("GetPending returned %+v", p)
+	}
+	if err := s.PutPending(ctx, "s1", login.Pending{StartAt: at, From: "/b"}); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := s.GetPending(ctx, "s1"); p.From != "/b" {
+		t.Errorf("PutPending didn't replace: %+v", p)
+	}
+	if err := s.DeletePending(ctx, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetPending(ctx, "s1"); err != login.ErrNotFound {
+		t.Errorf("GetPending of deleted state: %v", err)
+	}
+	if err := s.DeletePending(ctx, "s1"); err != nil {
+		t.Errorf("DeletePending of unknown state: %v", err)
+	}
+}
+
+func testUser(t *testing.T, s login.Store) {
+	ctx := context.Background()
+	if _, err := s.GetUser(ctx, "unknown"); err != login.ErrNotFound {
+		t.Errorf("GetUser of unknown id: %v", err)
+	}
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tok := &oauth2.Token{AccessToken: "at", TokenType: "Bearer", RefreshToken: "rt", Expiry: expiry}
+	claims := &login.Claims{Subject: "u1", Audience: login.Audience{"client"}, Email: "u1@example.com", Extra: map[string]interface{}{"groups": "dev"}}
+	if err := s.PutUser(ctx, "u1", login.User{Token: tok, Session: "s1", Provider: "p1", Claims: claims, IDToken: "it"}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.GetUser(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Session != "s1" || u.Provider != "p1" || u.IDToken != "it" || u.Token == nil || u.AccessToken != "at" || u.RefreshToken != "rt" || u.TokenType != "Bearer" || !u.Expiry.Equal(expiry) {
+		t.Errorf("GetUser returned %+v %+v", u, u.Token)
+	}
+	if c := u.Claims; c == nil || c.Subject != "u1" || len(c.Audience) != 1 || c.Email != "u1@example.com" || c.Extra["groups"] != "dev" {
+		t.Errorf("GetUser returned claims %+v", c)
+	}
+	if err := s.PutUser(ctx, "u2", login.User{Token: &oauth2.Token{AccessToken: "other"}, Session: "s2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUser(ctx, "u1"); err != login.ErrNotFound {
+		t.Errorf("GetUser of deleted id: %v", err)
+	}
+	if u, err := s.GetUser(ctx, "u2"); err != nil || u.AccessToken != "other" {
+		t.Errorf("DeleteUser deleted another user: %+v, %v", u, err)
+	}
+}
+
+func testUserLogins(t *testing.T, s login.Store) {
+	ctx := context.Background()
+	tok := &oauth2.Token{}
+	s.PutUser(ctx, "k1", login.User{Token: tok, ID: "a"})
+	s.PutUser(ctx, "k2", login.User{Token: tok, ID: "a"})
+	s.PutUser(ctx, "k3", login.User{Token: tok, ID: "b"})
+	s.PutUser(ctx, "k4", login.User{Token: tok})
+	// k5 moves from a to c
+	s.PutUser(ctx, "k5", login.User{Token: tok, ID: "a"})
+	s.PutUser(ctx, "k5", login.User{Token: tok, ID: "c"})
+	if err := s.DeleteUserLogins(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"k1", "k2"} {
+		if _, err := s.GetUser(ctx, k); err != login.ErrNotFound {
+			t.Errorf("GetUser of deleted login %s: %v", k, err)
+		}
+	}
+	if err := s.DeleteUserLogins(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"k3", "k4", "k5"} {
+		if _, err := s.GetUser(ctx, k); err != nil {
+			t.Errorf("GetUser of login %s of another user: %v", k, err)
+		}
+	}
+	// a login deleted by DeleteUser isn't found again
+	s.DeleteUser(ctx, "k3")
+	s.PutUser(ctx, "k6", login.User{Token: tok, ID: "b"})
+	if err := s.DeleteUserLogins(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUser(ctx, "k6"); err != login.ErrNotFound {
+		t.Errorf("GetUser of deleted login k6: %v", err)
+	}
+	if _, err := s.GetUser(ctx, "k5"); err != nil {
+		t.Errorf("GetUser of login k5 of another user: %v", err)
+	}
+}
+
+func testConcurrent(t *testing.T, s login.Store) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprint("u", i)
+			for j := 0; j < 10; j++ {
+				tok := &oauth2.Token{AccessToken: fmt.Sprint(j)}
+				if err := s.PutUser(ctx, id, login.User{Token: tok, Session: id}); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := s.GetUser(ctx, id); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		if u, err := s.GetUser(ctx, fmt.Sprint("u", i)); err != nil || u.AccessToken != "9" {
+			t.Errorf("u%d: %+v, %v", i, u, err)
+		}
+	}
+
+	// puts of one id replace each other
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				tok := &oauth2.Token{AccessToken: fmt.Sprint(i)}
+				if err := s.PutUser(ctx, "shared", login.User{Token: tok}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if _, err := s.GetUser(ctx, "shared"); err != nil {
+		t.Error(err)
+	}
+}
+
+func testSweep(t *testing.T, s login.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	s.PutPending(ctx, "old", login.Pending{StartAt: now.Add(-time.Hour)})
+	s.PutPending(ctx, "new", login.Pending{StartAt: now})
+	s.PutUser(ctx, "u1", login.User{Token: &oauth2.Token{}, Session: "expired"})
+	s.PutUser(ctx, "u2", login.User{Token: &oauth2.Token{}, Session: "active"})
+	n, err := s.Sweep(ctx, now.Add(-time.Minute), func(u login.User) bool { return u.Session == "expired" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != (login.Counts{Pending: 1, Users: 1}) {
+		t.Errorf("Sweep left %+v", n)
+	}
+	if _, err := s.GetPending(ctx, "old"); err != login.ErrNotFound {
+		t.Errorf("GetPending of swept state: %v", err)
+	}
+	if _, err := s.GetPending(ctx, "new"); err != nil {
+		t.Errorf("GetPending of new state: %v", err)
+	}
+	if _, err := s.GetUser(ctx, "u1"); err != login.ErrNotFound {
+		t.Errorf("GetUser of swept user: %v", err)
+	}
+	if _, err := s.GetUser(ctx, "u2"); err != nil {
+		t.Errorf("GetUser of active user: %v", err)
+	}
+}